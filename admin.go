@@ -0,0 +1,392 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PurpleSec/logx"
+)
+
+var errNoRecord = errors.New("record not found")
+
+type record struct {
+	ID      uint64 `json:"id"`
+	Bot     int64  `json:"bot"`
+	File    string `json:"file"`
+	Image   string `json:"image"`
+	Message uint64 `json:"message"`
+}
+type apiBot struct {
+	ID       int64   `json:"id"`
+	Mode     string  `json:"mode"`
+	Users    []int64 `json:"authorized_users"`
+	Channel  int64   `json:"channel_id"`
+	Username string  `json:"username"`
+}
+type apiError struct {
+	Error string `json:"error"`
+}
+type apiRehash struct {
+	Old     record `json:"old"`
+	New     record `json:"new"`
+	Changed bool   `json:"changed"`
+}
+type apiUser struct {
+	User int64 `json:"user"`
+}
+type apiImport struct {
+	File   string `json:"file,omitempty"`
+	Export string `json:"export,omitempty"`
+}
+
+func reply(w http.ResponseWriter, c int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(c)
+	json.NewEncoder(w).Encode(v)
+}
+func fail(w http.ResponseWriter, c int, s string) {
+	reply(w, c, apiError{Error: s})
+}
+func (f *Forwarder) lookup(i int64) *container {
+	for _, c := range f.list() {
+		if c.bot.Self().ID == i {
+			return c
+		}
+	}
+	return nil
+}
+func (c *container) addUser(u int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i := range c.users {
+		if c.users[i] == u {
+			return false
+		}
+	}
+	c.users = append(c.users, u)
+	return true
+}
+func (c *container) removeUser(u int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i := range c.users {
+		if c.users[i] != u {
+			continue
+		}
+		c.users = append(c.users[:i], c.users[i+1:]...)
+		return true
+	}
+	return false
+}
+
+// authorize applies the authorized user changes made through the admin API, which
+// are kept in the Storage, on top of the users in the config.
+func (c *container) authorize(x context.Context, f *Forwarder) {
+	u, err := f.sql.Users(x, c.bot.Self().ID)
+	if err != nil {
+		f.event(x, logx.Error, "Received an error loading the authorized user changes: %s!", err.Error())
+		return
+	}
+	for k, v := range u {
+		if v {
+			c.addUser(k)
+		} else {
+			c.removeUser(k)
+		}
+	}
+}
+func (c *container) info() apiBot {
+	c.lock.RLock()
+	u := make([]int64, len(c.users))
+	copy(u, c.users)
+	c.lock.RUnlock()
+	b := apiBot{ID: c.bot.Self().ID, Mode: "polling", Users: u, Channel: c.channel(), Username: c.bot.Self().UserName}
+	if len(c.hookPath()) > 0 {
+		b.Mode = "webhook"
+	}
+	return b
+}
+func recordOf(i Image) record {
+	return record{ID: i.ID, Bot: i.Bot, File: i.File, Image: strconv.FormatUint(i.Hash, 16), Message: i.Message}
+}
+func records(e []Image, err error) ([]record, error) {
+	if err != nil {
+		return nil, err
+	}
+	r := make([]record, len(e))
+	for i := range e {
+		r[i] = recordOf(e[i])
+	}
+	return r, nil
+}
+func (f *Forwarder) record(x context.Context, i uint64) (record, error) {
+	v, ok, err := f.sql.Get(x, i)
+	if err != nil {
+		return record{}, err
+	}
+	if !ok {
+		return record{}, errNoRecord
+	}
+	return recordOf(v), nil
+}
+func (f *Forwarder) bound(x context.Context, b int64) ([]record, error) {
+	var e []record
+	err := f.sql.List(x, b, func(i Image) error {
+		e = append(e, recordOf(i))
+		return nil
+	})
+	return e, err
+}
+func (f *Forwarder) apiImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var v apiImport
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil || (len(v.File) == 0) == (len(v.Export) == 0) {
+		fail(w, http.StatusBadRequest, `one of "file" or "export" is required`)
+		return
+	}
+	p := v.File
+	if len(p) == 0 {
+		p = v.Export
+	}
+	if err := f.submit(p, len(v.Export) > 0, nil); err != nil {
+		fail(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	reply(w, http.StatusAccepted, v)
+}
+func (f *Forwarder) authorized(r *http.Request) bool {
+	t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(t), []byte(f.token)) == 1
+}
+func (f *Forwarder) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(r) {
+		fail(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	p := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	switch {
+	case p[0] == "bots":
+		f.apiBots(w, r, p[1:])
+	case p[0] == "records":
+		f.apiRecords(w, r, p[1:])
+	case p[0] == "import" && len(p) == 1:
+		f.apiImport(w, r)
+	default:
+		fail(w, http.StatusNotFound, "unknown endpoint")
+	}
+}
+func (f *Forwarder) apiBots(w http.ResponseWriter, r *http.Request, p []string) {
+	if len(p) == 0 || len(p[0]) == 0 {
+		if r.Method != http.MethodGet {
+			fail(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		l := f.list()
+		b := make([]apiBot, 0, len(l))
+		for _, c := range l {
+			b = append(b, c.info())
+		}
+		reply(w, http.StatusOK, b)
+		return
+	}
+	i, err := strconv.ParseInt(p[0], 10, 64)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "invalid bot id")
+		return
+	}
+	c := f.lookup(i)
+	if c == nil {
+		fail(w, http.StatusNotFound, "bot not found")
+		return
+	}
+	switch {
+	case len(p) == 1 && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, c.info())
+	case len(p) == 2 && p[1] == "users" && r.Method == http.MethodPost:
+		var v apiUser
+		if err = json.NewDecoder(r.Body).Decode(&v); err != nil || v.User == 0 {
+			fail(w, http.StatusBadRequest, "invalid user")
+			return
+		}
+		if err = f.sql.SetUser(r.Context(), c.bot.Self().ID, v.User, true); err != nil {
+			fail(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if c.addUser(v.User) {
+			f.event(c.scope(r.Context()), logx.Info, "Authorized user %d via the admin API.", v.User)
+		}
+		reply(w, http.StatusOK, c.info())
+	case len(p) == 3 && p[1] == "users" && r.Method == http.MethodDelete:
+		u, err := strconv.ParseInt(p[2], 10, 64)
+		if err != nil {
+			fail(w, http.StatusBadRequest, "invalid user")
+			return
+		}
+		if !c.isAuthorized(u) {
+			fail(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err = f.sql.SetUser(r.Context(), c.bot.Self().ID, u, false); err != nil {
+			fail(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.removeUser(u)
+		f.event(c.scope(r.Context()), logx.Info, "Removed user %d via the admin API.", u)
+		reply(w, http.StatusOK, c.info())
+	default:
+		fail(w, http.StatusNotFound, "unknown endpoint")
+	}
+}
+func (f *Forwarder) apiRecords(w http.ResponseWriter, r *http.Request, p []string) {
+	if len(p) == 0 || len(p[0]) == 0 {
+		if r.Method != http.MethodGet {
+			fail(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var (
+			q    = r.URL.Query()
+			b, _ = strconv.ParseInt(q.Get("bot"), 10, 64)
+			h, m = q.Get("hash"), q.Get("message")
+			e    []record
+			n    uint64
+			err  error
+		)
+		switch {
+		case len(h) == 128:
+			e, err = records(f.sql.FindFile(r.Context(), b, strings.ToLower(h)))
+		case len(h) > 0:
+			if n, err = strconv.ParseUint(h, 16, 64); err != nil {
+				fail(w, http.StatusBadRequest, "invalid hash")
+				return
+			}
+			e, err = records(f.sql.FindHash(r.Context(), b, n))
+		case len(m) > 0:
+			if n, err = strconv.ParseUint(m, 10, 64); err != nil {
+				fail(w, http.StatusBadRequest, "invalid message id")
+				return
+			}
+			e, err = records(f.sql.FindMessage(r.Context(), b, n))
+		default:
+			fail(w, http.StatusBadRequest, `one of "hash" or "message" is required`)
+			return
+		}
+		if err != nil {
+			fail(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		reply(w, http.StatusOK, e)
+		return
+	}
+	i, err := strconv.ParseUint(p[0], 10, 64)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "invalid record id")
+		return
+	}
+	k, err := f.record(r.Context(), i)
+	if err == errNoRecord {
+		fail(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		fail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch {
+	case len(p) == 1 && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, k)
+	case len(p) == 1 && r.Method == http.MethodDelete:
+		c := f.lookup(k.Bot)
+		if c == nil {
+			fail(w, http.StatusConflict, "record belongs to an unknown bot")
+			return
+		}
+		// The delete is only saved to the Queue before the record is removed,
+		// the sender thread sends it when woken, or on the next start if the
+		// bot is not running.
+		var q uint64
+		if k.Message > 0 {
+			if q, err = f.sql.Enqueue(r.Context(), k.Bot, Task{Chat: c.channel(), Message: k.Message, Action: actionDelete}); err != nil {
+				fail(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		if err = f.sql.Remove(r.Context(), k.ID); err != nil {
+			if q > 0 {
+				f.sql.Dequeue(r.Context(), q)
+			}
+			fail(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.notify()
+		f.event(c.scope(r.Context()), logx.Info, `Removed record "%d" (Message "%d") via the admin API.`, k.ID, k.Message)
+		reply(w, http.StatusOK, k)
+	case len(p) == 2 && p[1] == "rehash" && r.Method == http.MethodPost:
+		c := f.lookup(k.Bot)
+		if c == nil {
+			fail(w, http.StatusConflict, "record belongs to an unknown bot")
+			return
+		}
+		v, err := c.rehash(r.Context(), f, k, true)
+		if err == errNoScratch {
+			fail(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			fail(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		reply(w, http.StatusOK, v)
+	default:
+		fail(w, http.StatusNotFound, "unknown endpoint")
+	}
+}
+
+// rehash fetches the media of the Message the record points to and recomputes
+// the hashes. If 'w' is true, the record is updated when the hashes differ.
+func (c *container) rehash(x context.Context, f *Forwarder, k record, w bool) (apiRehash, error) {
+	if k.Message == 0 {
+		return apiRehash{}, errors.New("record is not bound to a message")
+	}
+	i, m, err := c.fetch(x, f, int(k.Message))
+	if err != nil {
+		return apiRehash{}, err
+	}
+	d, err := loadImage(x, f, c, i, m)
+	if err != nil {
+		return apiRehash{}, err
+	}
+	v := apiRehash{Old: k, New: k}
+	v.New.File, v.New.Image = d.Sum, strconv.FormatUint(d.Average, 16)
+	if v.Changed = v.New.File != k.File || v.New.Image != k.Image; !v.Changed || !w {
+		return v, nil
+	}
+	if err = f.sql.Update(x, k.ID, d.Average, d.Sum); err != nil {
+		return apiRehash{}, err
+	}
+	f.event(c.scope(x), logx.Info, `Rehashed record "%d" from "%s" to "%s".`, k.ID, k.Image, v.New.Image)
+	return v, nil
+}
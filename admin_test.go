@@ -0,0 +1,78 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+const testToken = "admin-secret"
+
+func TestAdminAuth(t *testing.T) {
+	var (
+		a = newFakeAPI(t)
+		m = new(memory)
+		x = context.Background()
+	)
+	f, err := NewWithConfig(Config{
+		Log:  LogConfig{Level: 5},
+		HTTP: HTTPConfig{Token: testToken, Listen: "127.0.0.1:0"},
+		Bots: []BotConfig{{Key: fakeToken, API: a.api(), Files: a.fileURL(), Channel: testChannel}},
+	}, WithStorage(m), WithHTTPClient(a.srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Insert(x, []Image{{Bot: 1234, Hash: 1, File: "file1", Message: 10}})
+	e, _ := m.FindMessage(x, 1234, 10)
+	for _, v := range []struct {
+		name, method, path, auth string
+		code                     int
+	}{
+		{"missing", http.MethodGet, "/api/bots", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/api/bots", "Bearer nope", http.StatusUnauthorized},
+		{"wrong scheme", http.MethodGet, "/api/bots", "Basic " + testToken, http.StatusUnauthorized},
+		{"token prefix", http.MethodGet, "/api/bots", "Bearer " + testToken[:5], http.StatusUnauthorized},
+		{"unauthorized delete", http.MethodDelete, "/api/records/" + strconv.FormatUint(e[0].ID, 10), "", http.StatusUnauthorized},
+		{"list bots", http.MethodGet, "/api/bots", "Bearer " + testToken, http.StatusOK},
+		{"unknown endpoint", http.MethodGet, "/api/nope", "Bearer " + testToken, http.StatusNotFound},
+		{"delete record", http.MethodDelete, "/api/records/" + strconv.FormatUint(e[0].ID, 10), "Bearer " + testToken, http.StatusOK},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			var (
+				r = httptest.NewRequest(v.method, v.path, nil)
+				w = httptest.NewRecorder()
+			)
+			if len(v.auth) > 0 {
+				r.Header.Set("Authorization", v.auth)
+			}
+			if f.web.mux.ServeHTTP(w, r); w.Code != v.code {
+				t.Fatalf("expected status %d, got %d: %s", v.code, w.Code, w.Body.String())
+			}
+		})
+	}
+	if n := m.count(); n != 0 {
+		t.Fatalf("expected the record to be removed, got %d records", n)
+	}
+	q, _ := m.Pending(x, 1234)
+	if len(q) != 1 || q[0].Chat != testChannel || q[0].Message != 10 || q[0].Action != actionDelete {
+		t.Fatalf("expected the delete of Message 10 to be queued, got %+v", q)
+	}
+}
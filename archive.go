@@ -0,0 +1,154 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/PurpleSec/logx"
+)
+
+// manifestFile is the name of the manifest in each archive directory.
+const manifestFile = "manifest.jsonl"
+
+// archived is a manifest entry, written as one JSON object per line each time
+// an Image is posted. The original file is stored in the archive directory by
+// its SHA512 hash (the same as the record file hash).
+type archived struct {
+	Sum     string    `json:"sum"`
+	Time    time.Time `json:"time"`
+	FileID  string    `json:"file_id"`
+	Caption string    `json:"caption,omitempty"`
+	Channel int64     `json:"channel_id"`
+	Message int       `json:"message_id"`
+}
+
+// validSum returns true if the string is a SHA512 hash as written by 'hex',
+// exactly 128 lowercase hex characters. Only these are used as archive paths.
+func validSum(s string) bool {
+	if len(s) != sha512.Size*2 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// archivePath returns the path of the file hash in the archive directory, or an
+// empty string if the hash is not valid.
+func archivePath(d, s string) string {
+	if !validSum(s) {
+		return ""
+	}
+	return filepath.Join(d, s[:2], s)
+}
+func (c *container) archiveDir() string {
+	c.lock.RLock()
+	d := c.archive
+	c.lock.RUnlock()
+	return d
+}
+
+// save stores the Image data in the archive directory, if the bot has one, and
+// adds a manifest entry for the posted Message.
+func (c *container) save(x context.Context, f *Forwarder, i fileData, m int, s string) {
+	d := c.archiveDir()
+	if len(d) == 0 || !validSum(i.Sum) {
+		return
+	}
+	if err := writeArchive(d, i.Sum, i.data); err != nil {
+		f.event(x, logx.Warning, `Could not archive %s: %s!`, i, err.Error())
+		return
+	}
+	err := writeManifest(d, archived{Sum: i.Sum, Time: time.Now(), FileID: i.FileID, Caption: s, Channel: c.channel(), Message: m})
+	if err != nil {
+		f.event(x, logx.Warning, `Could not add %s to the archive manifest: %s!`, i, err.Error())
+		return
+	}
+	f.event(x, logx.Trace, `Archived %s as Message "%d".`, i, m)
+}
+func writeArchive(d, s string, b []byte) error {
+	p := archivePath(d, s)
+	if len(p) == 0 {
+		return errors.New(`invalid file hash "` + s + `"`)
+	}
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+	if len(b) == 0 {
+		return errors.New("no file data")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".tmp", b, 0640); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+func writeManifest(d string, a archived) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	v, err := os.OpenFile(filepath.Join(d, manifestFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err = v.Write(append(b, '\n')); err != nil {
+		v.Close()
+		return err
+	}
+	return v.Close()
+}
+func readManifest(d string) ([]archived, error) {
+	v, err := os.Open(filepath.Join(d, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	defer v.Close()
+	var (
+		r []archived
+		s = bufio.NewScanner(v)
+	)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var a archived
+		if err = json.Unmarshal(s.Bytes(), &a); err != nil {
+			return nil, errors.New(`cannot parse line "` + strconv.Itoa(n) + `": ` + err.Error())
+		}
+		if len(a.Sum) < 2 {
+			continue
+		}
+		r = append(r, a)
+	}
+	return r, s.Err()
+}
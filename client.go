@@ -0,0 +1,139 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Client is an interface for the Telegram Bot API calls made by each bot. The
+// default Client (returned by 'NewClient') uses the "telegram-bot-api" library,
+// and can be replaced or wrapped with the 'WithClient' Option.
+type Client interface {
+	// Self returns the bot account the Client is logged in as.
+	Self() telegram.User
+	// Send makes the request and returns the resulting Message.
+	Send(x context.Context, n telegram.Chattable) (telegram.Message, error)
+	// Request makes the request and returns the API response.
+	Request(x context.Context, n telegram.Chattable) (*telegram.APIResponse, error)
+	// Call makes a request by method name, for methods that do not have a
+	// Chattable type. Any files are uploaded with the request.
+	Call(x context.Context, m string, p telegram.Params, f ...telegram.RequestFile) (*telegram.APIResponse, error)
+	// GetFile returns the File info for the File ID, which contains the path
+	// used to download it.
+	GetFile(x context.Context, id string) (telegram.File, error)
+	// DownloadFile opens the file at the download URL.
+	DownloadFile(x context.Context, u string) (io.ReadCloser, error)
+	// GetUpdates returns the updates after the offset in the UpdateConfig, waiting
+	// up to the timeout for new ones (long polling).
+	GetUpdates(x context.Context, c telegram.UpdateConfig) ([]telegram.Update, error)
+}
+type botClient struct {
+	*telegram.BotAPI
+}
+type ctxClient struct {
+	x context.Context
+	h telegram.HTTPClient
+}
+
+// WithClient is an Option that sets the function used to create the Client for
+// each bot in the Config. Use 'NewClient' in the function to wrap the default
+// Client.
+func WithClient(f func(BotConfig) (Client, error)) Option {
+	return func(o *options) {
+		o.dial = f
+	}
+}
+
+// NewClient returns the default Client for the bot, which logs in to the Bot API
+// with the key in the BotConfig. If the HTTP client is nil, a default one is
+// used.
+func NewClient(b BotConfig, h *http.Client) (Client, error) {
+	if h == nil {
+		h = &http.Client{
+			Transport: &http.Transport{
+				Proxy:             http.ProxyFromEnvironment,
+				MaxIdleConns:      256,
+				ForceAttemptHTTP2: false,
+			},
+		}
+	}
+	v, err := telegram.NewBotAPIWithClient(b.Key, b.API, h)
+	if err != nil {
+		return nil, err
+	}
+	return botClient{v}, nil
+}
+func (c botClient) Self() telegram.User {
+	return c.BotAPI.Self
+}
+
+// with returns a copy of the BotAPI that makes its requests with the context, as
+// the library does not take a context for requests.
+func (c botClient) with(x context.Context) *telegram.BotAPI {
+	b := *c.BotAPI
+	b.Client = ctxClient{x: x, h: c.Client}
+	return &b
+}
+func (c ctxClient) Do(r *http.Request) (*http.Response, error) {
+	return c.h.Do(r.WithContext(c.x))
+}
+func (c botClient) GetFile(x context.Context, id string) (telegram.File, error) {
+	return c.with(x).GetFile(telegram.FileConfig{FileID: id})
+}
+func (c botClient) GetUpdates(x context.Context, u telegram.UpdateConfig) ([]telegram.Update, error) {
+	return c.with(x).GetUpdates(u)
+}
+func (c botClient) DownloadFile(x context.Context, u string) (io.ReadCloser, error) {
+	r, err := http.NewRequestWithContext(x, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.Client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if d.StatusCode != http.StatusOK {
+		d.Body.Close()
+		return nil, errors.New("file download returned " + d.Status)
+	}
+	return d.Body, nil
+}
+func (c botClient) Send(x context.Context, n telegram.Chattable) (telegram.Message, error) {
+	r, err := c.Request(x, n)
+	if err != nil {
+		return telegram.Message{}, err
+	}
+	var m telegram.Message
+	err = json.Unmarshal(r.Result, &m)
+	return m, err
+}
+func (c botClient) Request(x context.Context, n telegram.Chattable) (*telegram.APIResponse, error) {
+	return c.with(x).Request(n)
+}
+func (c botClient) Call(x context.Context, m string, p telegram.Params, f ...telegram.RequestFile) (*telegram.APIResponse, error) {
+	if len(f) > 0 {
+		return c.with(x).UploadFiles(m, p, f)
+	}
+	return c.with(x).MakeRequest(m, p)
+}
@@ -17,8 +17,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
 
 	"github.com/PurpleSec/forwarder"
 )
@@ -35,16 +40,55 @@ Usage:
   -V         Print version string and exit.
   -f <file>  Configuration file path.
   -d         Dump the default configuration and exit.
+  -check-config
+             Validate the configuration file and exit without connecting
+              to the database or Telegram. Settings overridden by the
+              environment are listed with their source.
   -I <file>  Import existing Channel Message data into the database.
               This requires using the "import.py" tool.
+  -dry-run   Used with "-I" to report how many records are new, duplicates,
+              near-duplicates or invalid without importing them. Conflicting
+              Message ID pairs are written to Stdout as CSV.
+  -distance <n>
+             Hamming distance used by "-dry-run" to find near-duplicate
+              Images. Defaults to 4.
+  -import-export <dir>
+             Import the Messages in a Telegram Desktop "Export chat history"
+              folder into the database. The export must include photos.
+  -export <file>
+             Export every record in the database to <file> in the format
+              used by "-I". Files ending in ".csv" are written as CSV.
+  -verify <n>
+             Sample up to <n> records of each bot, fetch the posted media and
+              check the stored hashes match. Media is forwarded to the
+              "scratch_chat_id" of each bot to be fetched.
+  -repair    Update mismatched records found with "-verify".
+  -migrate <channel_id>
+             Repost the records of a bot in their original order to a new
+              Channel and update the records to the new Messages. Running
+              it again resumes an interrupted migration.
+  -restore-channel <channel_id>
+             Same as "-migrate", but reposts the records that still point
+              to the old <channel_id> to the bot's current Channel.
+  -bot <id>  Bot ID used by "-migrate" and "-restore-channel", required
+              if there is more than one bot.
+  -drop-failed
+             Remove the records that "-migrate" or "-restore-channel" could
+              not post and update the rest, instead of stopping.
   -clear-all Clear the database of ALL DATA before starting up.
 `
 
 func main() {
 	var (
 		args             = flag.NewFlagSet("Forwarder Telegram Bot "+version+"_"+buildVersion, flag.ExitOnError)
-		file, imp        string
+		file, imp, exp   string
+		out              string
 		dump, empty, ver bool
+		check, repair    bool
+		dry, drop        bool
+		verify, dist     int
+		id               int64
+		migrate, restore int64
 	)
 	args.Usage = func() {
 		os.Stderr.WriteString(usage)
@@ -54,7 +98,18 @@ func main() {
 	args.BoolVar(&dump, "d", false, "")
 	args.BoolVar(&ver, "V", false, "")
 	args.StringVar(&imp, "I", "", "")
+	args.StringVar(&exp, "import-export", "", "")
+	args.StringVar(&out, "export", "", "")
 	args.BoolVar(&empty, "clear-all", false, "")
+	args.BoolVar(&check, "check-config", false, "")
+	args.IntVar(&verify, "verify", 0, "")
+	args.BoolVar(&repair, "repair", false, "")
+	args.BoolVar(&dry, "dry-run", false, "")
+	args.IntVar(&dist, "distance", 4, "")
+	args.Int64Var(&restore, "restore-channel", 0, "")
+	args.Int64Var(&migrate, "migrate", 0, "")
+	args.Int64Var(&id, "bot", 0, "")
+	args.BoolVar(&drop, "drop-failed", false, "")
 
 	if err := args.Parse(os.Args[1:]); err != nil {
 		os.Stderr.WriteString(usage)
@@ -76,22 +131,129 @@ func main() {
 		os.Exit(0)
 	}
 
+	if check {
+		c, err := forwarder.CheckConfig(file)
+		if err != nil {
+			os.Stdout.WriteString("Error: " + err.Error() + "!\n")
+			os.Exit(1)
+		}
+		if o := c.Overrides(); len(o) > 0 {
+			k := make([]string, 0, len(o))
+			for v := range o {
+				k = append(k, v)
+			}
+			sort.Strings(k)
+			os.Stdout.WriteString("Overridden settings:\n")
+			for _, v := range k {
+				os.Stdout.WriteString("  " + v + ": " + o[v] + "\n")
+			}
+		}
+		os.Stdout.WriteString("Configuration OK.\n")
+		os.Exit(0)
+	}
+
+	if dry && len(imp) == 0 {
+		os.Stderr.WriteString(usage)
+		os.Exit(2)
+	}
+
+	if len(out) > 0 {
+		s, err := forwarder.NewOffline(file, false)
+		if err == nil {
+			err = s.Export(out)
+		}
+		if err != nil {
+			os.Stdout.WriteString("Error: " + err.Error() + "!\n")
+			os.Exit(1)
+		}
+		os.Stdout.WriteString("Export Complete.\n")
+		os.Exit(0)
+	}
+
+	if len(imp) > 0 || len(exp) > 0 {
+		s, err := forwarder.NewOffline(file, empty && !dry)
+		if err != nil {
+			os.Stdout.WriteString("Error: " + err.Error() + "!\n")
+			os.Exit(1)
+		}
+		if dry {
+			if _, err = s.DryRun(imp, dist, os.Stdout); err != nil {
+				os.Stderr.WriteString("Error: " + err.Error() + "!\n")
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		if len(imp) > 0 {
+			err = s.Import(imp)
+		} else {
+			err = s.ImportExport(exp)
+		}
+		if err != nil {
+			os.Stdout.WriteString("Error: " + err.Error() + "!\n")
+			os.Exit(1)
+		}
+		os.Stdout.WriteString("Import Complete.\n")
+		os.Exit(0)
+	}
+
 	s, err := forwarder.New(file, empty)
 	if err != nil {
 		os.Stdout.WriteString("Error: " + err.Error() + "!\n")
 		os.Exit(1)
 	}
 
-	if len(imp) > 0 {
-		if err := s.Import(imp); err != nil {
+	if verify > 0 {
+		r, err := s.Verify(verify, repair)
+		for _, v := range r {
+			os.Stdout.WriteString(
+				"Bot " + strconv.FormatInt(v.Bot, 10) + ": checked " + strconv.Itoa(v.Checked) + ", failed " + strconv.Itoa(v.Failed) +
+					", image mismatches " + strconv.Itoa(v.Image) + " (" + strconv.FormatFloat(v.Rate()*100, 'f', 2, 64) + "%)" +
+					", file mismatches " + strconv.Itoa(v.File) + ", repaired " + strconv.Itoa(v.Repaired) + "\n",
+			)
+		}
+		if err != nil {
 			os.Stdout.WriteString("Error: " + err.Error() + "!\n")
 			os.Exit(1)
 		}
-		os.Stdout.WriteString("Import Complete.\n")
 		os.Exit(0)
 	}
 
-	if err := s.Run(); err != nil {
+	if migrate != 0 {
+		if err = s.Migrate(id, migrate, drop); err != nil {
+			os.Stdout.WriteString("Error: " + err.Error() + "!\n")
+			os.Exit(1)
+		}
+		os.Stdout.WriteString("Migration Complete.\n")
+		os.Exit(0)
+	}
+
+	if restore != 0 {
+		if err = s.Restore(id, restore, drop); err != nil {
+			os.Stdout.WriteString("Error: " + err.Error() + "!\n")
+			os.Exit(1)
+		}
+		os.Stdout.WriteString("Restore Complete.\n")
+		os.Exit(0)
+	}
+
+	var (
+		o    = make(chan os.Signal, 1)
+		x, y = context.WithCancel(context.Background())
+	)
+	signal.Notify(o, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+	go func() {
+		for v := range o {
+			if v == syscall.SIGHUP {
+				s.Reload()
+				continue
+			}
+			y()
+			return
+		}
+	}()
+	err = s.Run(x)
+	signal.Stop(o)
+	if y(); err != nil {
 		os.Stdout.WriteString("Error: " + err.Error() + "!\n")
 		os.Exit(1)
 	}
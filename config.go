@@ -19,8 +19,12 @@ package forwarder
 import (
 	"errors"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/PurpleSec/logx"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
 	// Import for the Golang MySQL driver
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -37,12 +41,32 @@ const Defaults = `{
 	},
 	"log": {
 		"file": "forwarder.log",
-		"level": 2
+		"level": 2,
+		"format": "text"
+	},
+	"http": {
+		"token": "",
+		"listen": ""
+	},
+	"import": {
+		"directory": ""
+	},
+	"webhook": {
+		"url": "",
+		"listen": "",
+		"cert": "",
+		"key": "",
+		"self_signed": false
 	},
 	"bots": [
 		{
 			"channel_id": 0,
 			"telegram_key": "",
+			"api_endpoint": "",
+			"file_endpoint": "",
+			"archive_directory": "",
+			"max_file_size": 0,
+			"scratch_chat_id": 0,
 			"authorized_users": [
 				0,
 				1
@@ -52,47 +76,150 @@ const Defaults = `{
 }
 `
 
-type log struct {
-	File  string `json:"file"`
-	Level int    `json:"level"`
+// LogConfig is the log section of a Config.
+type LogConfig struct {
+	File   string `json:"file" yaml:"file" toml:"file"`
+	Level  int    `json:"level" yaml:"level" toml:"level"`
+	Format string `json:"format" yaml:"format" toml:"format"`
+}
+
+// HTTPConfig is the HTTP listener section of a Config.
+type HTTPConfig struct {
+	Token  string `json:"token" yaml:"token" toml:"token"`
+	Listen string `json:"listen" yaml:"listen" toml:"listen"`
+}
+
+// WebhookConfig is the Webhook section of a Config.
+type WebhookConfig struct {
+	URL        string `json:"url" yaml:"url" toml:"url"`
+	Key        string `json:"key" yaml:"key" toml:"key"`
+	Cert       string `json:"cert" yaml:"cert" toml:"cert"`
+	Listen     string `json:"listen" yaml:"listen" toml:"listen"`
+	SelfSigned bool   `json:"self_signed" yaml:"self_signed" toml:"self_signed"`
 }
-type bot struct {
-	Key     string  `json:"telegram_key"`
-	Users   []int64 `json:"authorized_users"`
-	Channel int64   `json:"channel_id"`
+
+// ImportConfig is the import section of a Config.
+type ImportConfig struct {
+	Directory string `json:"directory" yaml:"directory" toml:"directory"`
+}
+
+// BotConfig is the config of a single bot in a Config.
+type BotConfig struct {
+	Key     string  `json:"telegram_key" yaml:"telegram_key" toml:"telegram_key"`
+	API     string  `json:"api_endpoint" yaml:"api_endpoint" toml:"api_endpoint"`
+	Files   string  `json:"file_endpoint" yaml:"file_endpoint" toml:"file_endpoint"`
+	Users   []int64 `json:"authorized_users" yaml:"authorized_users" toml:"authorized_users"`
+	Channel int64   `json:"channel_id" yaml:"channel_id" toml:"channel_id"`
+	Archive string  `json:"archive_directory" yaml:"archive_directory" toml:"archive_directory"`
+	MaxSize int64   `json:"max_file_size" yaml:"max_file_size" toml:"max_file_size"`
+	Scratch int64   `json:"scratch_chat_id" yaml:"scratch_chat_id" toml:"scratch_chat_id"`
 }
-type config struct {
-	Log      log      `json:"log"`
-	Bots     []bot    `json:"bots"`
-	Database database `json:"db"`
+
+// Config is the configuration of a Forwarder. Use 'LoadConfig' to read a config
+// file or build one directly for 'NewWithConfig'. The JSON format is the same
+// as the 'Defaults' string.
+type Config struct {
+	Log      LogConfig      `json:"log" yaml:"log" toml:"log"`
+	HTTP     HTTPConfig     `json:"http" yaml:"http" toml:"http"`
+	Bots     []BotConfig    `json:"bots" yaml:"bots" toml:"bots"`
+	Import   ImportConfig   `json:"import" yaml:"import" toml:"import"`
+	Webhook  WebhookConfig  `json:"webhook" yaml:"webhook" toml:"webhook"`
+	Database DatabaseConfig `json:"db" yaml:"db" toml:"db"`
+
+	src map[string]string
 }
-type database struct {
-	Name     string        `json:"database"`
-	Server   string        `json:"host"`
-	Timeout  time.Duration `json:"timeout"`
-	Username string        `json:"user"`
-	Password string        `json:"password"`
+
+// DatabaseConfig is the MySQL database section of a Config.
+type DatabaseConfig struct {
+	Name     string        `json:"database" yaml:"database" toml:"database"`
+	Server   string        `json:"host" yaml:"host" toml:"host"`
+	Timeout  time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	Username string        `json:"user" yaml:"user" toml:"user"`
+	Password string        `json:"password" yaml:"password" toml:"password"`
 }
 
-func (c *config) check() error {
+// checkDatabase validates the database section, which is only needed when the
+// default MySQL Storage is used.
+func (c *Config) checkDatabase() error {
 	if len(c.Database.Name) == 0 {
-		return errors.New("missing database name")
+		return errors.New("missing database name" + c.from("db.database"))
 	}
 	if len(c.Database.Server) == 0 {
-		return errors.New("missing database server")
+		return errors.New("missing database server" + c.from("db.host"))
 	}
 	if len(c.Database.Username) == 0 {
-		return errors.New("missing database username")
+		return errors.New("missing database username" + c.from("db.user"))
+	}
+	return nil
+}
+func (c *Config) check() error {
+	// The bot defaults are set on a copy of the bots, as the Config is passed by
+	// value and the caller still shares the backing array.
+	c.Bots = append([]BotConfig(nil), c.Bots...)
+	if c.Database.Timeout < 0 {
+		return errors.New("database timeout cannot be negative" + c.from("db.timeout"))
 	}
 	if c.Database.Timeout == 0 {
 		c.Database.Timeout = time.Minute * 3
 	}
+	if c.Log.Level < int(logx.Trace) || c.Log.Level > int(logx.Fatal) {
+		return errors.New("log level must be between 0 and 5" + c.from("log.level"))
+	}
+	switch c.Log.Format {
+	case "":
+		c.Log.Format = formatText
+	case formatText, formatJSON:
+	default:
+		return errors.New(`log format must be "text" or "json"` + c.from("log.format"))
+	}
+	if len(c.HTTP.Token) > 0 && len(c.HTTP.Listen) == 0 {
+		return errors.New("http token requires a listen address" + c.from("http.listen"))
+	}
+	if len(c.Webhook.URL) > 0 {
+		if !strings.HasPrefix(c.Webhook.URL, "https://") {
+			return errors.New("webhook url must use https" + c.from("webhook.url"))
+		}
+		if len(c.Webhook.Listen) == 0 {
+			return errors.New("missing webhook listen address" + c.from("webhook.listen"))
+		}
+		if (len(c.Webhook.Cert) == 0) != (len(c.Webhook.Key) == 0) {
+			return errors.New("webhook cert and key must be used together" + c.from("webhook.cert") + c.from("webhook.key"))
+		}
+		if c.Webhook.SelfSigned && len(c.Webhook.Cert) == 0 {
+			return errors.New("webhook self_signed requires a cert" + c.from("webhook.self_signed"))
+		}
+	}
 	for i := range c.Bots {
+		p := "bots." + strconv.Itoa(i) + "."
 		if c.Bots[i].Channel == 0 {
-			return errors.New("bot " + strconv.Itoa(i) + ": missing channel_id")
+			return errors.New("bot " + strconv.Itoa(i) + ": missing channel_id" + c.from(p+"channel_id"))
 		}
 		if len(c.Bots[i].Key) == 0 {
-			return errors.New("bot " + strconv.Itoa(i) + ": missing telegram_key")
+			return errors.New("bot " + strconv.Itoa(i) + ": missing telegram_key" + c.from(p+"telegram_key"))
+		}
+		for x := 0; x < i; x++ {
+			if c.Bots[x].Key == c.Bots[i].Key {
+				return errors.New("bot " + strconv.Itoa(i) + ": duplicate telegram_key of bot " + strconv.Itoa(x) + c.from(p+"telegram_key"))
+			}
+		}
+		if len(c.Bots[i].API) == 0 {
+			c.Bots[i].API = telegram.APIEndpoint
+		} else if strings.Count(c.Bots[i].API, "%s") != 2 {
+			return errors.New("bot " + strconv.Itoa(i) + `: api_endpoint must contain two "%s" placeholders` + c.from(p+"api_endpoint"))
+		}
+		if len(c.Bots[i].Files) == 0 {
+			c.Bots[i].Files = telegram.FileEndpoint
+		} else if strings.Count(c.Bots[i].Files, "%s") != 2 {
+			return errors.New("bot " + strconv.Itoa(i) + `: file_endpoint must contain two "%s" placeholders` + c.from(p+"file_endpoint"))
+		}
+		if c.Bots[i].MaxSize < 0 {
+			return errors.New("bot " + strconv.Itoa(i) + ": max_file_size cannot be negative" + c.from(p+"max_file_size"))
+		}
+		if c.Bots[i].MaxSize == 0 {
+			c.Bots[i].MaxSize = maxFileSize
+		}
+		if c.Bots[i].Scratch != 0 && c.Bots[i].Scratch == c.Bots[i].Channel {
+			return errors.New("bot " + strconv.Itoa(i) + ": scratch_chat_id cannot be the channel_id" + c.from(p+"scratch_chat_id"))
 		}
 	}
 	return nil
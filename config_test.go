@@ -0,0 +1,135 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigDecode(t *testing.T) {
+	for _, v := range []struct {
+		name, file, data, err string
+	}{
+		{"json", "f.json", `{"log": {"level": 3}, "bots": [{"channel_id": -1, "authorized_users": [1]}]}`, ""},
+		{"json unknown key", "f.conf", "{\n\t\"log\": {},\n\t\"nope\": 1\n}", `line 3: unknown key "nope"`},
+		{"json unknown nested key", "f.json", "{\"bots\": [\n\t{\"chanel_id\": 1}\n]}", `line 2: unknown key "bots.0.chanel_id"`},
+		{"json any value", "f.json", `{"bots": [{"authorized_users": [1, 2]}], "db": {"timeout": 5}}`, ""},
+		{"json syntax", "f.json", "{\n\t\"log\": {,\n}", "line 2: "},
+		{"json type", "f.json", "{\n\t\"log\": {\"level\": \"high\"}\n}", "line 2: "},
+		{"yaml", "f.yml", "log:\n  level: 3\nbots:\n  - channel_id: -1\n", ""},
+		{"yaml unknown key", "f.yaml", "log:\n  levle: 3\n", `field levle not found`},
+		{"toml", "f.toml", "[log]\nlevel = 3\n\n[[bots]]\nchannel_id = -1\n", ""},
+		{"toml unknown key", "f.TOML", "[log]\nlevel = 3\nlevle = 1\n", `line 3: unknown key "log.levle"`},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			var c Config
+			err := c.decode(v.file, []byte(v.data))
+			switch {
+			case len(v.err) == 0 && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case len(v.err) > 0 && err == nil:
+				t.Fatalf("expected an error containing %q", v.err)
+			case len(v.err) > 0 && !strings.Contains(err.Error(), v.err):
+				t.Fatalf("expected an error containing %q, got %q", v.err, err)
+			}
+			if len(v.err) == 0 && c.Log.Level != 3 && len(c.Bots) == 0 {
+				t.Fatalf("config was not decoded: %+v", c)
+			}
+		})
+	}
+}
+func TestConfigEnviron(t *testing.T) {
+	s := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(s, []byte("5678:file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		name string
+		env  map[string]string
+		err  string
+		ok   func(Config) bool
+		key  string
+		src  string
+	}{
+		{
+			"int", map[string]string{"FORWARDER_LOG_LEVEL": "4"}, "",
+			func(c Config) bool { return c.Log.Level == 4 }, "log.level", "env FORWARDER_LOG_LEVEL",
+		},
+		{
+			"duration", map[string]string{"FORWARDER_DB_TIMEOUT": "30s"}, "",
+			func(c Config) bool { return c.Database.Timeout == time.Second*30 }, "db.timeout", "env FORWARDER_DB_TIMEOUT",
+		},
+		{
+			"list", map[string]string{"FORWARDER_BOTS_0_AUTHORIZED_USERS": "7, 8,"}, "",
+			func(c Config) bool { return len(c.Bots[0].Users) == 2 && c.Bots[0].Users[1] == 8 }, "bots.0.authorized_users", "env FORWARDER_BOTS_0_AUTHORIZED_USERS",
+		},
+		{
+			"new bot", map[string]string{"FORWARDER_BOTS_1_CHANNEL_ID": "-5"}, "",
+			func(c Config) bool { return len(c.Bots) == 2 && c.Bots[0].Key == fakeToken && c.Bots[1].Channel == -5 }, "bots.1.channel_id", "env FORWARDER_BOTS_1_CHANNEL_ID",
+		},
+		{
+			"file", map[string]string{"FORWARDER_BOTS_0_TELEGRAM_KEY_FILE": s}, "",
+			func(c Config) bool { return c.Bots[0].Key == "5678:file" }, "bots.0.telegram_key", `file "` + s + `" (FORWARDER_BOTS_0_TELEGRAM_KEY_FILE)`,
+		},
+		{
+			"invalid", map[string]string{"FORWARDER_LOG_LEVEL": "high"}, "FORWARDER_LOG_LEVEL: invalid value", nil, "", "",
+		},
+		{
+			"missing file", map[string]string{"FORWARDER_HTTP_TOKEN_FILE": s + ".missing"}, "FORWARDER_HTTP_TOKEN_FILE: ", nil, "", "",
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			for k, e := range v.env {
+				t.Setenv(k, e)
+			}
+			c := Config{Bots: []BotConfig{{Key: fakeToken, Channel: testChannel}}}
+			err := c.environ()
+			if len(v.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), v.err) {
+					t.Fatalf("expected an error containing %q, got %v", v.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !v.ok(c) {
+				t.Fatalf("override was not applied: %+v", c)
+			}
+			if o := c.Overrides(); len(o) != 1 || o[v.key] != v.src {
+				t.Fatalf("unexpected overrides %v", o)
+			}
+		})
+	}
+}
+func TestConfigCheckCopy(t *testing.T) {
+	c := Config{Bots: []BotConfig{{Key: fakeToken, Channel: testChannel}}}
+	v := c
+	if err := v.check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Bots[0].API) == 0 || v.Bots[0].MaxSize == 0 {
+		t.Fatalf("defaults were not set: %+v", v.Bots[0])
+	}
+	if len(c.Bots[0].API) > 0 || c.Bots[0].MaxSize > 0 {
+		t.Fatalf("check changed the original bots: %+v", c.Bots[0])
+	}
+}
@@ -17,7 +17,9 @@
 package forwarder
 
 var cleanStatements = []string{
-	`DROP TABLES IF EXISTS Images`,
+	`DROP TABLE IF EXISTS Images`,
+	`DROP TABLE IF EXISTS Queue`,
+	`DROP TABLE IF EXISTS Users`,
 	`DROP PROCEDURE IF EXISTS AddImage`,
 	`DROP PROCEDURE IF EXISTS DeleteImage`,
 }
@@ -28,27 +30,51 @@ var setupStatements = []string{
 		ImageHash BIGINT(64) UNSIGNED NOT NULL,
 		ImageFileHash CHAR(128) NOT NULL,
 		ImageBotID BIGINT(64) UNSIGNED NOT NULL,
-		ImageMessageID BIGINT(64) UNSIGNED NOT NULL
+		ImageMessageID BIGINT(64) UNSIGNED NOT NULL,
+		UNIQUE KEY ImageBotHash(ImageBotID, ImageHash)
 	)`,
-	`CREATE PROCEDURE IF NOT EXISTS DeleteImage(Hash1 CHAR(128), BotID BIGINT(64) UNSIGNED)
-	BEGIN
-		SET @image_message = COALESCE((SELECT ImageMessageID FROM Images WHERE ImageFileHash = Hash1 AND ImageBotID = BotID LIMIT 1), 0);
-		IF @image_message <> 0 THEN
-			DELETE FROM Images WHERE ImageMessageID = @image_message AND ImageFileHash = Hash1 AND ImageBotID = BotID;
-		END IF;
-		SELECT @image_message;
-	END;`,
-	`CREATE PROCEDURE IF NOT EXISTS AddImage(Hash1 BIGINT(64) UNSIGNED, Hash2 CHAR(128), BotID BIGINT(64) UNSIGNED, MessageID BIGINT(64) UNSIGNED)
-	BEGIN
-		SET @image_hash = COALESCE((SELECT ImageHash FROM Images WHERE ImageHash = Hash1 AND ImageBotID = BotID LIMIT 1), 0);
-		IF @image_hash = 0 THEN
-			INSERT INTO Images(ImageHash, ImageFileHash, ImageBotID, ImageMessageID) VALUES(Hash1, Hash2, BotID, MessageID);
-		END IF;
-		SELECT @image_hash;
-	END;`,
+	`CREATE TABLE IF NOT EXISTS Queue(
+		QueueID BIGINT(64) UNSIGNED NOT NULL PRIMARY KEY AUTO_INCREMENT,
+		QueueBotID BIGINT(64) UNSIGNED NOT NULL,
+		QueueChatID BIGINT(64) NOT NULL,
+		QueueMessageID BIGINT(64) UNSIGNED NOT NULL,
+		QueueAction TINYINT(8) UNSIGNED NOT NULL,
+		QueueTime DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS Users(
+		UserBotID BIGINT(64) UNSIGNED NOT NULL,
+		UserID BIGINT(64) NOT NULL,
+		UserAllowed BOOLEAN NOT NULL,
+		PRIMARY KEY(UserBotID, UserID)
+	)`,
+}
+
+// upgradeStatements add the keys missing from tables made by older versions. Each
+// is only run if the key named by the first value does not exist.
+var upgradeStatements = [][2]string{
+	{"ImageBotHash", `ALTER TABLE Images ADD UNIQUE KEY ImageBotHash(ImageBotID, ImageHash)`},
 }
 
 var queryStatements = map[string]string{
-	"add":    `CALL AddImage(?, ?, ?, ?)`,
-	"delete": `CALL DeleteImage(?, ?)`,
+	"image_get":     `SELECT ImageID, ImageHash, ImageFileHash, ImageBotID, ImageMessageID FROM Images WHERE ImageID = ?`,
+	"image_bind":    `UPDATE Images SET ImageMessageID = ? WHERE ImageID = ?`,
+	"image_file":    `SELECT ImageID, ImageHash, ImageFileHash, ImageBotID, ImageMessageID FROM Images WHERE (? = 0 OR ImageBotID = ?) AND ImageFileHash = ?`,
+	"image_hash":    `SELECT ImageID, ImageHash, ImageFileHash, ImageBotID, ImageMessageID FROM Images WHERE (? = 0 OR ImageBotID = ?) AND ImageHash = ?`,
+	"image_list":    `SELECT ImageID, ImageHash, ImageFileHash, ImageBotID, ImageMessageID FROM Images WHERE (? = 0 OR ImageBotID = ?) AND ImageMessageID > 0 ORDER BY ImageBotID, ImageMessageID`,
+	"image_delete":  `DELETE FROM Images WHERE ImageMessageID = ? AND ImageFileHash = ? AND ImageBotID = ?`,
+	"image_remove":  `DELETE FROM Images WHERE ImageID = ?`,
+	"image_sample":  `SELECT ImageID, ImageHash, ImageFileHash, ImageBotID, ImageMessageID FROM Images WHERE (? = 0 OR ImageBotID = ?) AND ImageMessageID > 0 ORDER BY RAND() LIMIT ?`,
+	"image_update":  `UPDATE Images SET ImageHash = ?, ImageFileHash = ? WHERE ImageID = ?`,
+	"image_deleted": `SELECT ImageMessageID FROM Images WHERE ImageFileHash = ? AND ImageBotID = ? AND ImageMessageID > 0 LIMIT 1`,
+	"image_message": `SELECT ImageID, ImageHash, ImageFileHash, ImageBotID, ImageMessageID FROM Images WHERE (? = 0 OR ImageBotID = ?) AND ImageMessageID = ?`,
+	"image_release": `DELETE FROM Images WHERE ImageID = ? AND ImageMessageID = 0`,
+	"image_reserve": `INSERT INTO Images(ImageHash, ImageFileHash, ImageBotID, ImageMessageID) VALUES(?, ?, ?, 0) ON DUPLICATE KEY UPDATE ImageID = LAST_INSERT_ID(ImageID)`,
+	"image_unbound": `DELETE FROM Images WHERE ImageBotID = ? AND ImageMessageID = 0`,
+
+	"queue_add":    `INSERT INTO Queue(QueueBotID, QueueChatID, QueueMessageID, QueueAction) VALUES(?, ?, ?, ?)`,
+	"queue_list":   `SELECT QueueID, QueueChatID, QueueMessageID, QueueAction FROM Queue WHERE QueueBotID = ? ORDER BY QueueID`,
+	"queue_remove": `DELETE FROM Queue WHERE QueueID = ?`,
+
+	"user_set":  `REPLACE INTO Users(UserBotID, UserID, UserAllowed) VALUES(?, ?, ?)`,
+	"user_list": `SELECT UserID, UserAllowed FROM Users WHERE UserBotID = ?`,
 }
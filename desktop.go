@@ -0,0 +1,210 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// channelPrefix is added to the Channel IDs in a Telegram Desktop export to
+// create the Bot API "-100" prefixed Channel ID.
+const channelPrefix = 1000000000000
+
+// desktopExport is the chat in the "result.json" file created by the Telegram
+// Desktop "Export chat history" option. The "messages" array is read as a stream
+// after the chat fields, as it can be very large.
+type desktopExport struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+type desktopMessage struct {
+	ID    uint64 `json:"id"`
+	Type  string `json:"type"`
+	File  string `json:"file"`
+	Mime  string `json:"mime_type"`
+	Photo string `json:"photo"`
+}
+
+// botID returns the Bot ID of the bot, which is the numeric prefix of the token.
+func (b BotConfig) botID() (uint64, error) {
+	v, _, ok := strings.Cut(b.Key, ":")
+	if !ok {
+		return 0, errors.New("invalid telegram_key")
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+// ImportExport will attempt to import the Messages in a Telegram Desktop "Export
+// chat history" folder, which contains a "result.json" file and the exported
+// photos. The images are hashed locally and the Channel is matched to a bot in
+// the config using the Channel ID, so no network access is needed.
+func (f *Forwarder) ImportExport(d string) error {
+	x, y := interruptible()
+	err := f.loadExport(x, d)
+	if y(); err != nil {
+		f.sql.Close()
+	}
+	return err
+}
+func (f *Forwarder) loadExport(x context.Context, d string) error {
+	f.log.Info(`Attempting to import Messages from the export in "%s"..`, d)
+	v, err := os.Open(filepath.Join(d, "result.json"))
+	if err != nil {
+		return errors.New(`cannot open the export in "` + d + `": ` + err.Error())
+	}
+	defer v.Close()
+	j := json.NewDecoder(v)
+	if t, err := j.Token(); err != nil || t != json.Delim('{') {
+		return errors.New(`cannot parse the export in "` + d + `": expected a JSON object`)
+	}
+	var (
+		e desktopExport
+		r []imported
+		n bool
+	)
+	for j.More() {
+		t, err := j.Token()
+		if err != nil {
+			return errors.New(`cannot parse the export in "` + d + `": ` + err.Error())
+		}
+		switch t {
+		case "id":
+			err = j.Decode(&e.ID)
+		case "name":
+			err = j.Decode(&e.Name)
+		case "messages":
+			if r, err = f.readExport(x, j, d, e); err != nil {
+				return err
+			}
+			n = true
+		default:
+			var s json.RawMessage
+			err = j.Decode(&s)
+		}
+		if err != nil {
+			return errors.New(`cannot parse the export in "` + d + `": ` + err.Error())
+		}
+	}
+	if !n {
+		return errors.New(`export in "` + d + `" is not a single chat export`)
+	}
+	return f.insert(x, d, r)
+}
+
+// readExport reads the "messages" array of the export 'e' from the decoder and
+// hashes the images, returning the records to import. The Channel of the export
+// must match the Channel of a bot in the config.
+func (f *Forwarder) readExport(x context.Context, j *json.Decoder, d string, e desktopExport) ([]imported, error) {
+	if e.ID == 0 {
+		return nil, errors.New(`export in "` + d + `" is not a single chat export`)
+	}
+	f.lock.RLock()
+	l := f.conf.Bots
+	f.lock.RUnlock()
+	var (
+		k   = -(channelPrefix + e.ID)
+		b   uint64
+		err error
+	)
+	for i := range l {
+		if l[i].Channel != k {
+			continue
+		}
+		if b, err = l[i].botID(); err != nil {
+			return nil, errors.New("bot " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		break
+	}
+	if b == 0 {
+		return nil, errors.New(`no bot is configured for the Channel "` + e.Name + `" (` + strconv.FormatInt(k, 10) + `)`)
+	}
+	if t, err := j.Token(); err != nil || t != json.Delim('[') {
+		return nil, errors.New(`cannot parse the export in "` + d + `": expected a messages array`)
+	}
+	f.log.Info(`Reading the Messages in the export of "%s" for bot %d..`, e.Name, b)
+	var (
+		r []imported
+		h = make(map[uint64]struct{})
+	)
+	for j.More() {
+		if err = x.Err(); err != nil {
+			return nil, err
+		}
+		var m desktopMessage
+		if err = j.Decode(&m); err != nil {
+			return nil, errors.New(`cannot parse the export in "` + d + `": ` + err.Error())
+		}
+		p, t := m.Photo, "image/jpeg"
+		if len(p) == 0 {
+			p, t = m.File, m.Mime
+		}
+		if m.Type != "message" || len(p) == 0 || !strings.HasPrefix(t, "image/") {
+			continue
+		}
+		if strings.HasPrefix(p, "(") {
+			f.log.Warning(`Skipping Message "%d" as the file was not included in the export.`, m.ID)
+			continue
+		}
+		if !filepath.IsLocal(p) {
+			f.log.Warning(`Skipping Message "%d" with the invalid file path "%s".`, m.ID, p)
+			continue
+		}
+		i, err := hashFile(filepath.Join(d, p), t)
+		if err == errNotImage {
+			f.log.Debug(`Skipping non-image Message "%d".`, m.ID)
+			continue
+		}
+		if err != nil {
+			f.log.Warning(`Skipping Message "%d" as "%s" cannot be read: %s!`, m.ID, p, err.Error())
+			continue
+		}
+		if _, ok := h[i.Average]; ok {
+			f.log.Debug(`Duplicate of "%X" detected in Message "%d", skipping it..`, i.Average, m.ID)
+			continue
+		}
+		h[i.Average] = struct{}{}
+		r = append(r, imported{ID: m.ID, Bot: b, File: i.Sum, Image: strconv.FormatUint(i.Average, 16)})
+	}
+	if _, err = j.Token(); err != nil {
+		return nil, errors.New(`cannot parse the export in "` + d + `": ` + err.Error())
+	}
+	return r, nil
+}
+
+// hashFile reads and hashes the image file at the supplied path, using the same
+// hashing as images submitted to the bots.
+func hashFile(p, mime string) (fileData, error) {
+	v, err := os.Open(p)
+	if err != nil {
+		return fileData{}, err
+	}
+	d, s, err := readImage(v, 0)
+	if err != nil {
+		return fileData{}, err
+	}
+	h, err := hashImage(d, mime)
+	if err != nil {
+		return fileData{}, err
+	}
+	return fileData{Sum: s, Average: h}, nil
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportExport(t *testing.T) {
+	var (
+		a = newFakeAPI(t)
+		m = new(memory)
+		d = t.TempDir()
+	)
+	if err := os.Mkdir(filepath.Join(d, "photos"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string][]byte{"photos/a.jpg": fakeImage(t, 1), "photos/b.jpg": fakeImage(t, 1), "photos/c.jpg": fakeImage(t, 2)} {
+		if err := os.WriteFile(filepath.Join(d, k), v, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The "messages" array is not the last key, and the Messages include each
+	// kind of entry that is skipped.
+	err := os.WriteFile(filepath.Join(d, "result.json"), []byte(`{
+		"name": "Channel",
+		"type": "public_channel",
+		"id": 1,
+		"messages": [
+			{"id": 1, "type": "message", "photo": "photos/a.jpg", "text": [{"type": "bold", "text": "a"}]},
+			{"id": 2, "type": "service", "action": "pin_message"},
+			{"id": 3, "type": "message", "photo": "photos/b.jpg"},
+			{"id": 4, "type": "message", "photo": "(File not included. Change data exporting settings to download.)"},
+			{"id": 5, "type": "message", "photo": "../photos/c.jpg"},
+			{"id": 6, "type": "message", "file": "photos/c.jpg", "mime_type": "image/jpeg"},
+			{"id": 7, "type": "message", "file": "photos/missing.jpg", "mime_type": "image/jpeg"}
+		],
+		"extra": {"values": [1, 2]}
+	}`), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewWithConfig(Config{
+		Log:  LogConfig{Level: 5},
+		Bots: []BotConfig{{Key: fakeToken, API: a.api(), Files: a.fileURL(), Channel: -(channelPrefix + 1)}},
+	}, WithStorage(m), WithHTTPClient(a.srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.loadExport(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	if e := messages(t, m, 1234); !equal(e, []uint64{1, 6}) {
+		t.Fatalf("expected Messages 1 and 6 to be imported, got %v", e)
+	}
+	if err = os.WriteFile(filepath.Join(d, "result.json"), []byte(`{"id": 2, "messages": []}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.loadExport(context.Background(), d); err == nil {
+		t.Fatal("expected an export of an unknown Channel to fail")
+	}
+}
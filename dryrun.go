@@ -0,0 +1,195 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math/bits"
+	"strconv"
+)
+
+// ImportReport is the result of an import dry run.
+type ImportReport struct {
+	// Total is the number of records in the import file.
+	Total int
+	// New is the number of records that would be added.
+	New int
+	// Exact is the number of records with an Image hash that already exists for
+	// the bot, which would be skipped.
+	Exact int
+	// Near is the number of records that would be added, but have an Image hash
+	// within the hamming distance of an existing Image.
+	Near int
+	// Invalid is the number of records that are missing values.
+	Invalid int
+}
+
+// bktree is a BK-tree of Image hashes using the hamming distance, used to find
+// similar hashes without comparing every pair.
+type bktree struct {
+	root *bknode
+}
+type bknode struct {
+	hash    uint64
+	message uint64
+	source  string
+	next    map[int]*bknode
+}
+
+func (t *bktree) add(h, m uint64, s string) {
+	if t.root == nil {
+		t.root = &bknode{hash: h, message: m, source: s}
+		return
+	}
+	for n := t.root; ; {
+		d := bits.OnesCount64(n.hash ^ h)
+		if d == 0 {
+			return
+		}
+		v, ok := n.next[d]
+		if !ok {
+			if n.next == nil {
+				n.next = make(map[int]*bknode)
+			}
+			n.next[d] = &bknode{hash: h, message: m, source: s}
+			return
+		}
+		n = v
+	}
+}
+
+// find returns the closest node with a distance of at most 'm' from the hash.
+func (t *bktree) find(h uint64, m int) (*bknode, int) {
+	if t.root == nil {
+		return nil, -1
+	}
+	var (
+		r *bknode
+		b = -1
+		q = []*bknode{t.root}
+	)
+	for len(q) > 0 {
+		n := q[len(q)-1]
+		q = q[:len(q)-1]
+		d := bits.OnesCount64(n.hash ^ h)
+		if d <= m && (b == -1 || d < b) {
+			r, b = n, d
+		}
+		for k, v := range n.next {
+			if k >= d-m && k <= d+m {
+				q = append(q, v)
+			}
+		}
+	}
+	return r, b
+}
+
+// DryRun reads the import file and reports how many records would be added or
+// skipped, without changing the database. Records are compared to the existing
+// records of each bot and the earlier records in the file. Records within the
+// hamming distance 'n' of an existing Image hash are counted as near-duplicates.
+//
+// Each conflicting pair of Message IDs is written to the supplied Writer as CSV,
+// if it is not nil.
+func (f *Forwarder) DryRun(s string, n int, w io.Writer) (ImportReport, error) {
+	x, y := interruptible()
+	r, err := f.dryRun(x, s, n, w)
+	y()
+	return r, err
+}
+func (f *Forwarder) dryRun(x context.Context, s string, n int, w io.Writer) (ImportReport, error) {
+	var r ImportReport
+	v, d, _, err := openImport(s)
+	if err != nil {
+		return r, err
+	}
+	defer v.Close()
+	var (
+		t = make(map[uint64]*bktree)
+		c *csv.Writer
+	)
+	if w != nil {
+		c = csv.NewWriter(w)
+		c.Write([]string{"bot", "message_id", "conflict_message_id", "distance", "source"})
+	}
+	for d.More() {
+		if err = x.Err(); err != nil {
+			return r, err
+		}
+		var e imported
+		if err = d.Decode(&e); err != nil {
+			return r, errors.New(`cannot parse record "` + strconv.Itoa(r.Total) + `" in "` + s + `": ` + err.Error())
+		}
+		r.Total++
+		h, ok := e.hash()
+		if !ok {
+			r.Invalid++
+			continue
+		}
+		b, ok := t[e.Bot]
+		if !ok {
+			if b, err = f.existing(x, e.Bot); err != nil {
+				return r, err
+			}
+			t[e.Bot] = b
+		}
+		k, i := b.find(h, n)
+		switch {
+		case i == 0:
+			r.Exact++
+		case k != nil:
+			r.Near++
+			fallthrough
+		default:
+			r.New++
+			b.add(h, e.ID, "import")
+		}
+		if k != nil && c != nil {
+			c.Write([]string{
+				strconv.FormatUint(e.Bot, 10), strconv.FormatUint(e.ID, 10), strconv.FormatUint(k.message, 10), strconv.Itoa(i), k.source,
+			})
+		}
+	}
+	if c != nil {
+		c.Flush()
+		err = c.Error()
+	}
+	f.log.Info(`Dry run of "%s": %d records, %d new, %d exact duplicates, %d near-duplicates, %d invalid.`,
+		s, r.Total, r.New, r.Exact, r.Near, r.Invalid,
+	)
+	return r, err
+}
+
+// existing returns a BK-tree of the Image hashes already stored for the bot.
+func (f *Forwarder) existing(x context.Context, b uint64) (*bktree, error) {
+	e, err := f.bound(x, int64(b))
+	if err != nil {
+		return nil, err
+	}
+	t := new(bktree)
+	for i := range e {
+		h, err := strconv.ParseUint(e[i].Image, 16, 64)
+		if err != nil {
+			continue
+		}
+		t.add(h, e[i].Message, "database")
+	}
+	return t, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import "testing"
+
+func TestBKTree(t *testing.T) {
+	var b bktree
+	if n, d := b.find(1, 64); n != nil || d != -1 {
+		t.Fatalf("empty tree returned %v, %d", n, d)
+	}
+	for i, h := range []uint64{0x0, 0xF, 0xFF, 0xFFFF, 0xF0F0F0F0, 0x1, 0xF} {
+		b.add(h, uint64(i+1), "test")
+	}
+	for _, v := range []struct {
+		name    string
+		hash    uint64
+		max     int
+		message uint64
+		dist    int
+	}{
+		{"exact root", 0x0, 0, 1, 0},
+		{"exact child", 0xFF, 0, 3, 0},
+		{"duplicate keeps first", 0xF, 0, 2, 0},
+		{"closest", 0x7, 4, 2, 1},
+		{"closest of many", 0x3FF, 8, 3, 2},
+		{"deep", 0xF0F0F0F1, 1, 5, 1},
+		{"too far", 0xFFFFFFFF00000000, 8, 0, -1},
+		{"zero distance miss", 0x2, 0, 0, -1},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			n, d := b.find(v.hash, v.max)
+			if d != v.dist {
+				t.Fatalf("expected distance %d, got %d", v.dist, d)
+			}
+			if v.dist == -1 {
+				if n != nil {
+					t.Fatalf("expected no match, got %+v", n)
+				}
+				return
+			}
+			if n == nil || n.message != v.message {
+				t.Fatalf("expected Message %d, got %+v", v.message, n)
+			}
+		})
+	}
+}
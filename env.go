@@ -0,0 +1,198 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is the prefix used for all config environment variable overrides.
+// Names are built from the upper-case JSON keys joined by underscores, with
+// list entries using their index (ex: "FORWARDER_BOTS_0_TELEGRAM_KEY").
+//
+// Adding the "_FILE" suffix to any name will read the value from the file path
+// it contains instead.
+const envPrefix = "FORWARDER"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func keyName(f reflect.StructField) string {
+	if len(f.PkgPath) > 0 {
+		return ""
+	}
+	n, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if n == "-" {
+		return ""
+	}
+	return n
+}
+func lookupEnv(e string) (string, string, bool, error) {
+	if v, ok := os.LookupEnv(e); ok {
+		return v, "env " + e, true, nil
+	}
+	p, ok := os.LookupEnv(e + "_FILE")
+	if !ok {
+		return "", "", false, nil
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", "", false, errors.New(e + "_FILE: " + err.Error())
+	}
+	return strings.TrimRight(string(b), "\r\n"), `file "` + p + `" (` + e + `_FILE)`, true, nil
+}
+
+// entries returns the number of list entries referenced by environment variables
+// with the supplied prefix, so lists can be extended by the environment.
+func entries(e string) int {
+	var n int
+	for _, v := range os.Environ() {
+		k, _, _ := strings.Cut(v, "=")
+		s, ok := strings.CutPrefix(k, e+"_")
+		if !ok {
+			continue
+		}
+		d, _, _ := strings.Cut(s, "_")
+		if i, err := strconv.Atoi(d); err == nil && i >= n {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// from returns a suffix describing where the config value at the supplied path
+// was set from, if it was not the config file.
+func (c *Config) from(p string) string {
+	if s, ok := c.src[p]; ok {
+		return " (from " + s + ")"
+	}
+	return ""
+}
+
+// Overrides returns the config keys (ex: "bots.0.telegram_key") that were set by
+// the environment when loaded with 'LoadConfig', mapped to the environment
+// variable or file the value came from. The values are not included.
+func (c *Config) Overrides() map[string]string {
+	r := make(map[string]string, len(c.src))
+	for k, v := range c.src {
+		r[k] = v
+	}
+	return r
+}
+func (c *Config) environ() error {
+	c.src = make(map[string]string)
+	return c.override(reflect.ValueOf(c).Elem(), envPrefix, "")
+}
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			n, err2 := strconv.ParseInt(s, 10, 64)
+			if err2 != nil {
+				return err
+			}
+			d = time.Duration(n)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		var (
+			p = strings.Split(s, ",")
+			r = reflect.MakeSlice(v.Type(), 0, len(p))
+		)
+		for i := range p {
+			if p[i] = strings.TrimSpace(p[i]); len(p[i]) == 0 {
+				continue
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(e, p[i]); err != nil {
+				return err
+			}
+			r = reflect.Append(r, e)
+		}
+		v.Set(r)
+	default:
+		return errors.New("unsupported type " + v.Type().String())
+	}
+	return nil
+}
+func (c *Config) override(v reflect.Value, e, p string) error {
+	switch {
+	case v.Kind() == reflect.Struct && v.Type() != durationType:
+		for i := 0; i < v.NumField(); i++ {
+			n := keyName(v.Type().Field(i))
+			if len(n) == 0 {
+				continue
+			}
+			k := n
+			if len(p) > 0 {
+				k = p + "." + n
+			}
+			if err := c.override(v.Field(i), e+"_"+strings.ToUpper(n), k); err != nil {
+				return err
+			}
+		}
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		if n := entries(e); n > v.Len() {
+			r := reflect.MakeSlice(v.Type(), n, n)
+			reflect.Copy(r, v)
+			v.Set(r)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := c.override(v.Index(i), e+"_"+strconv.Itoa(i), p+"."+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, src, ok, err := lookupEnv(e)
+	if err != nil || !ok {
+		return err
+	}
+	if err = setValue(v, s); err != nil {
+		return errors.New(e + ": invalid value: " + err.Error())
+	}
+	c.src[p] = src
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Export writes every bound record in the database to the supplied file path,
+// ordered by bot and Message ID. Files ending in ".csv" are written as CSV, all
+// others are written as JSON in the same format read by 'Import'.
+//
+// The file is written to a temporary file first and is only replaced once the
+// export is complete.
+func (f *Forwarder) Export(s string) error {
+	x, y := interruptible()
+	err := f.export(x, s)
+	if y(); err != nil {
+		f.sql.Close()
+	}
+	return err
+}
+func (f *Forwarder) export(x context.Context, s string) error {
+	f.log.Info(`Attempting to export records to file "%s"..`, s)
+	v, err := os.CreateTemp(filepath.Dir(s), "."+filepath.Base(s)+".*")
+	if err != nil {
+		return errors.New(`cannot create "` + s + `": ` + err.Error())
+	}
+	var (
+		w = bufio.NewWriter(v)
+		n int
+	)
+	if strings.EqualFold(filepath.Ext(s), ".csv") {
+		n, err = f.exportCSV(x, w)
+	} else {
+		n, err = f.exportJSON(x, w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if v.Close(); err == nil {
+		err = os.Rename(v.Name(), s)
+	}
+	if err != nil {
+		os.Remove(v.Name())
+		return errors.New(`cannot export to "` + s + `": ` + err.Error())
+	}
+	f.log.Info(`Exported %d records to "%s".`, n, s)
+	return nil
+}
+
+// each calls the function for every bound record in the database, in order.
+func (f *Forwarder) each(x context.Context, g func(imported) error) (int, error) {
+	var n int
+	err := f.sql.List(x, 0, func(i Image) error {
+		if err := g(imported{ID: i.Message, Bot: uint64(i.Bot), File: i.File, Image: strconv.FormatUint(i.Hash, 16)}); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+func (f *Forwarder) exportCSV(x context.Context, w io.Writer) (int, error) {
+	c := csv.NewWriter(w)
+	c.Write([]string{"bot", "id", "image", "file"})
+	n, err := f.each(x, func(i imported) error {
+		return c.Write([]string{strconv.FormatUint(i.Bot, 10), strconv.FormatUint(i.ID, 10), i.Image, i.File})
+	})
+	if c.Flush(); err == nil {
+		err = c.Error()
+	}
+	return n, err
+}
+func (f *Forwarder) exportJSON(x context.Context, w io.Writer) (int, error) {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return 0, err
+	}
+	p := "\n\t"
+	n, err := f.each(x, func(i imported) error {
+		if _, err := io.WriteString(w, p); err != nil {
+			return err
+		}
+		p = ",\n\t"
+		b, err := json.Marshal(i)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(w, "\n]\n")
+	return n, err
+}
@@ -0,0 +1,267 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeToken is the bot token accepted by the fake Bot API server. The bot ID is
+// the numeric prefix.
+const fakeToken = "1234:fake"
+
+// fakeAPI is an in-process Bot API server that supports the methods used by the
+// Forwarder. Every request is recorded, so tests can wait for the calls they
+// expect, and updates are delivered to "getUpdates" long polling.
+type fakeAPI struct {
+	srv     *httptest.Server
+	lock    sync.Mutex
+	next    int
+	gone    map[string]bool
+	files   map[string][]byte
+	calls   []*fakeCall
+	updates []telegram.Update
+}
+type fakeCall struct {
+	Method string
+	Form   url.Values
+	used   bool
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	a := &fakeAPI{next: 100, gone: make(map[string]bool), files: make(map[string][]byte)}
+	a.srv = httptest.NewServer(a)
+	t.Cleanup(a.srv.Close)
+	return a
+}
+func (a *fakeAPI) api() string {
+	return a.srv.URL + "/bot%s/%s"
+}
+func (a *fakeAPI) fileURL() string {
+	return a.srv.URL + "/file/bot%s/%s"
+}
+
+// file adds a downloadable file with the supplied File ID.
+func (a *fakeAPI) file(id string, b []byte) {
+	a.lock.Lock()
+	a.files[id] = b
+	a.lock.Unlock()
+}
+
+// photo queues an update with a photo sent to the bot in a private chat.
+func (a *fakeAPI) photo(u int64, id, caption string) {
+	a.lock.Lock()
+	a.next++
+	a.updates = append(a.updates, telegram.Update{
+		UpdateID: len(a.updates) + 1,
+		Message: &telegram.Message{
+			MessageID: a.next,
+			From:      &telegram.User{ID: u, FirstName: "User"},
+			Chat:      &telegram.Chat{ID: u, Type: "private"},
+			Date:      int(time.Now().Unix()),
+			Photo:     []telegram.PhotoSize{{FileID: id, FileUniqueID: "u" + id, Width: 64, Height: 64, FileSize: len(a.files[id])}},
+			Caption:   caption,
+		},
+	})
+	a.lock.Unlock()
+}
+
+// expect waits for an unmatched call to the method that matches the function (if
+// not nil) and marks it as matched.
+func (a *fakeAPI) expect(t *testing.T, m string, f func(url.Values) bool) url.Values {
+	t.Helper()
+	for d := time.Now().Add(time.Second * 20); time.Now().Before(d); time.Sleep(time.Millisecond * 10) {
+		a.lock.Lock()
+		for _, c := range a.calls {
+			if c.used || c.Method != m || (f != nil && !f(c.Form)) {
+				continue
+			}
+			c.used = true
+			a.lock.Unlock()
+			return c.Form
+		}
+		a.lock.Unlock()
+	}
+	t.Fatalf("timed out waiting for a %q call", m)
+	return nil
+}
+
+// count returns the number of calls to the method.
+func (a *fakeAPI) count(m string) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var n int
+	for _, c := range a.calls {
+		if c.Method == m {
+			n++
+		}
+	}
+	return n
+}
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+fakeToken+"/"); ok {
+		a.lock.Lock()
+		b, ok := a.files[strings.TrimSuffix(strings.TrimPrefix(p, "photos/"), ".jpg")]
+		a.lock.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+		return
+	}
+	m, ok := strings.CutPrefix(r.URL.Path, "/bot"+fakeToken+"/")
+	if !ok {
+		fakeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err := r.ParseMultipartForm(1 << 20); err == http.ErrNotMultipart {
+		r.ParseForm()
+	}
+	if m != "getUpdates" {
+		defer a.record(m, r.Form)
+	}
+	switch m {
+	case "getMe":
+		fakeResult(w, telegram.User{ID: 1234, IsBot: true, FirstName: "Fake", UserName: "fake_bot"})
+	case "getUpdates":
+		fakeResult(w, a.poll(r))
+	case "getFile":
+		i := r.Form.Get("file_id")
+		a.lock.Lock()
+		_, ok := a.files[i]
+		a.lock.Unlock()
+		if !ok {
+			fakeError(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+			return
+		}
+		fakeResult(w, telegram.File{FileID: i, FileUniqueID: "u" + i, FilePath: "photos/" + i + ".jpg"})
+	case "sendPhoto", "sendMessage", "sendVideo", "sendAnimation":
+		fakeResult(w, a.message(r.Form, 0))
+	case "copyMessage":
+		a.lock.Lock()
+		g := a.gone[r.Form.Get("message_id")]
+		if !g {
+			a.next++
+		}
+		n := a.next
+		a.lock.Unlock()
+		if g {
+			fakeError(w, http.StatusBadRequest, "Bad Request: message to copy not found")
+			return
+		}
+		fakeResult(w, telegram.MessageID{MessageID: n})
+	case "editMessageMedia":
+		n, _ := strconv.Atoi(r.Form.Get("message_id"))
+		fakeResult(w, a.message(r.Form, n))
+	case "deleteMessage", "setWebhook", "deleteWebhook":
+		fakeResult(w, true)
+	default:
+		fakeError(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+// record adds a call once it was handled, so any values added while handling it
+// are visible to 'expect'.
+func (a *fakeAPI) record(m string, v url.Values) {
+	a.lock.Lock()
+	a.calls = append(a.calls, &fakeCall{Method: m, Form: v})
+	a.lock.Unlock()
+}
+
+// poll returns the updates after the offset, waiting a short time for new ones
+// like a long polling request.
+func (a *fakeAPI) poll(r *http.Request) []telegram.Update {
+	o, _ := strconv.Atoi(r.Form.Get("offset"))
+	for d := time.Now().Add(time.Millisecond * 250); ; time.Sleep(time.Millisecond * 10) {
+		a.lock.Lock()
+		var u []telegram.Update
+		for _, v := range a.updates {
+			if v.UpdateID >= o {
+				u = append(u, v)
+			}
+		}
+		a.lock.Unlock()
+		if len(u) > 0 || time.Now().After(d) || r.Context().Err() != nil {
+			return u
+		}
+	}
+}
+
+// message returns the Message created by a send or edit request. If 'n' is zero,
+// a new Message ID is used and added to the recorded request as "message_id".
+func (a *fakeAPI) message(v url.Values, n int) telegram.Message {
+	k, _ := strconv.ParseInt(v.Get("chat_id"), 10, 64)
+	if n == 0 {
+		a.lock.Lock()
+		a.next++
+		n = a.next
+		a.lock.Unlock()
+		v.Set("message_id", strconv.Itoa(n))
+	}
+	t := "private"
+	if k < 0 {
+		t = "channel"
+	}
+	m := telegram.Message{MessageID: n, Chat: &telegram.Chat{ID: k, Type: t}, Date: int(time.Now().Unix()), Text: v.Get("text"), Caption: v.Get("caption")}
+	if i := v.Get("photo"); len(i) > 0 {
+		m.Photo = []telegram.PhotoSize{{FileID: i, FileUniqueID: "u" + i, Width: 64, Height: 64}}
+	}
+	return m
+}
+func fakeError(w http.ResponseWriter, c int, s string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(c)
+	json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": c, "description": s})
+}
+func fakeResult(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": v})
+}
+
+// fakeImage returns a JPEG image with a pattern based on the seed, so images with
+// different seeds have different perceptual hashes.
+func fakeImage(t *testing.T, s int) []byte {
+	t.Helper()
+	i := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if ((x/(4*s) + y/(8*s)) % 2) == 0 {
+				i.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, i, nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
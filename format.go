@@ -0,0 +1,152 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// CheckConfig reads and validates the config file at the supplied path, including
+// any environment overrides and the database section, without connecting to the
+// database or Telegram. The Config is returned so the overrides can be listed
+// with 'Overrides'.
+func CheckConfig(s string) (Config, error) {
+	c, err := LoadConfig(s)
+	if err != nil {
+		return c, err
+	}
+	return c, c.checkDatabase()
+}
+func lineOf(b []byte, o int64) string {
+	if o > int64(len(b)) {
+		o = int64(len(b))
+	}
+	return "line " + strconv.Itoa(bytes.Count(b[:o], []byte{'\n'})+1)
+}
+
+// decode parses the config data based on the file extension of the supplied
+// path. YAML and TOML files are detected by extension, everything else is
+// treated as JSON. Unknown keys are rejected in every format.
+func (c *Config) decode(s string, b []byte) error {
+	switch strings.ToLower(filepath.Ext(s)) {
+	case ".yml", ".yaml":
+		d := yaml.NewDecoder(bytes.NewReader(b))
+		d.KnownFields(true)
+		if err := d.Decode(c); err != nil {
+			return errors.New(strings.ReplaceAll(strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n  "), "\n  ", ", "))
+		}
+		return nil
+	case ".toml":
+		d := toml.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		err := d.Decode(c)
+		if err == nil {
+			return nil
+		}
+		var (
+			m *toml.StrictMissingError
+			e *toml.DecodeError
+		)
+		if errors.As(err, &m) && len(m.Errors) > 0 {
+			e = &m.Errors[0]
+			r, _ := e.Position()
+			return errors.New("line " + strconv.Itoa(r) + `: unknown key "` + strings.Join(e.Key(), ".") + `"`)
+		}
+		if errors.As(err, &e) {
+			r, _ := e.Position()
+			return errors.New("line " + strconv.Itoa(r) + ": " + strings.TrimPrefix(e.Error(), "toml: "))
+		}
+		return err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		var (
+			x *json.SyntaxError
+			t *json.UnmarshalTypeError
+		)
+		switch {
+		case errors.As(err, &x):
+			return errors.New(lineOf(b, x.Offset) + ": " + x.Error())
+		case errors.As(err, &t):
+			return errors.New(lineOf(b, t.Offset) + ": " + t.Error())
+		}
+		return err
+	}
+	return knownKeys(json.NewDecoder(bytes.NewReader(b)), b, reflect.TypeOf(c).Elem(), "")
+}
+
+// knownKeys walks the JSON tokens in the decoder and returns an error with the
+// line number of the first object key that does not match a field in the type.
+// A nil type skips the value without any checks.
+func knownKeys(d *json.Decoder, b []byte, t reflect.Type, p string) error {
+	v, err := d.Token()
+	if err != nil {
+		return err
+	}
+	switch v {
+	case json.Delim('{'):
+		for d.More() {
+			if v, err = d.Token(); err != nil {
+				return err
+			}
+			var (
+				k, _ = v.(string)
+				n    = k
+				e    reflect.Type
+			)
+			if len(p) > 0 {
+				n = p + "." + k
+			}
+			if t != nil && t.Kind() == reflect.Struct {
+				for i := 0; i < t.NumField(); i++ {
+					if keyName(t.Field(i)) == k {
+						e = t.Field(i).Type
+						break
+					}
+				}
+				if e == nil {
+					return errors.New(lineOf(b, d.InputOffset()) + `: unknown key "` + n + `"`)
+				}
+			}
+			if err = knownKeys(d, b, e, n); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		var e reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			e = t.Elem()
+		}
+		for i := 0; d.More(); i++ {
+			if err = knownKeys(d, b, e, p+"."+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	_, err = d.Token()
+	return err
+}
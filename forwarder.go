@@ -18,111 +18,128 @@ package forwarder
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
 	"errors"
-	"net/http"
+	"io"
 	"os"
 	"os/signal"
-	"strconv"
 	"sync"
 	"syscall"
 
 	"github.com/PurpleSec/logx"
-	"github.com/PurpleSec/mapper"
-
-	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 // Forwarder is a struct that contains the threads and config values that can be
 // used to run the Forwarder Telegram bot.
 //
-// Use the 'New' function to properly create a Forwarder.
+// Use the 'New' or 'NewWithConfig' functions to properly create a Forwarder.
 type Forwarder struct {
-	log    logx.Log
-	sql    *mapper.Map
-	bots   []*container
-	cancel context.CancelFunc
-	caps   maps[int64]
-	groups maps[string]
+	log     logx.Log
+	sql     Storage
+	custom  bool
+	web     *web
+	hook    *webhook
+	hooks   hooks
+	conf    Config
+	file    string
+	bots    []*container
+	lock    sync.RWMutex
+	stats   *metrics
+	token   string
+	jobs    chan job
+	dial    func(BotConfig) (Client, error)
+	ingest  sync.RWMutex
+	reloads chan Config
+	caps    maps[int64]
+	groups  maps[string]
 }
 
 // Run will start the main Forwarder process and all associated threads. This
-// function will block until an interrupt signal is received.
+// function will block until the supplied context is canceled. Use 'Reload' or
+// 'ReloadConfig' to apply config changes without stopping.
 //
 // This function returns any errors that occur during shutdown.
-func (f *Forwarder) Run() error {
+func (f *Forwarder) Run(x context.Context) error {
+	if len(f.list()) == 0 {
+		return errors.New("no telegram accounts are logged in")
+	}
 	var (
-		o = make(chan os.Signal, 1)
-		x context.Context
 		g sync.WaitGroup
+		y context.CancelFunc
 	)
-	signal.Notify(o, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	x, f.cancel = context.WithCancel(context.Background())
+	x, y = context.WithCancel(x)
 	f.log.Info("Forwarder Started, spinning up Bot threads..")
-	for i := range f.bots {
+	if f.web != nil {
+		if err := f.web.listen(f); err != nil {
+			f.log.Error(`HTTP listener on "%s" failed: %s!`, f.web.srv.Addr, err.Error())
+		} else {
+			f.log.Info(`HTTP listener started on "%s".`, f.web.srv.Addr)
+		}
+	}
+	if f.hook != nil {
+		if err := f.hook.listen(f); err != nil {
+			f.log.Error(`Webhook listener on "%s" failed, using polling instead: %s!`, f.hook.srv.Addr, err.Error())
+		} else {
+			f.log.Info(`Webhook listener started on "%s".`, f.hook.srv.Addr)
+		}
+	}
+	for i, c := range f.list() {
 		f.log.Debug("Starting bot %d..", i)
-		f.bots[i].start(x, f, &g)
+		c.start(x, f, &g)
 	}
 	go f.tick(x)
+	g.Add(1)
+	go f.importer(x, &g, f.conf.Import.Directory)
 	for {
 		select {
-		case <-o:
-			goto cleanup
+		case c := <-f.reloads:
+			f.reload(x, &g, c)
 		case <-x.Done():
 			goto cleanup
 		}
 	}
 cleanup:
-	signal.Stop(o)
-	f.cancel()
-	for i := range f.bots {
+	y()
+	if f.web != nil {
+		if err := f.web.shutdown(); err != nil {
+			f.log.Warning("HTTP listener shutdown failed: %s!", err.Error())
+		}
+	}
+	for i, c := range f.list() {
 		f.log.Debug("Stopping Bot %d..", i)
-		f.bots[i].stop()
+		c.stop(f)
+	}
+	if f.hook != nil {
+		if err := f.hook.shutdown(); err != nil {
+			f.log.Warning("Webhook listener shutdown failed: %s!", err.Error())
+		}
 	}
 	g.Wait()
-	close(o)
-	return f.sql.Close()
+	err := f.sql.Close()
+	if v, ok := f.log.(io.Closer); ok {
+		v.Close()
+	}
+	return err
 }
 
-// Import will attempt to import the data contained in the supplied filepath as
-// a JSON export using the "import.py" tool.
-func (f *Forwarder) Import(s string) error {
-	f.log.Info(`Attempting to import Messages from file "%s"..`, s)
-	v, err := os.Open(s)
-	if err != nil {
-		return errors.New(`cannot open "` + s + `": ` + err.Error())
-	}
-	var e []imported
-	err = json.NewDecoder(v).Decode(&e)
-	if v.Close(); err != nil {
-		return errors.New(`cannot parse "` + s + `": ` + err.Error())
-	}
-	f.log.Info(`Found "%d" records in "%s"..`, len(e), s)
+// interruptible returns a context that is canceled when an interrupt signal is
+// received. The returned function must be called to stop listening for signals.
+func interruptible() (context.Context, func()) {
 	var (
 		o    = make(chan os.Signal, 1)
 		x, y = context.WithCancel(context.Background())
 	)
 	signal.Notify(o, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	for i := range e {
-		if e[i].Bot == 0 || e[i].ID == 0 || len(e[i].File) == 0 || len(e[i].Image) == 0 {
-			f.log.Warning(`Skipping invalid record at "%d" in "%s".`, i, s)
-			continue
-		}
-		var h uint64
-		if h, err = strconv.ParseUint(e[i].Image, 16, 64); err != nil || h == 0 {
-			f.log.Warning(`Skipping invalid record at "%d" with bad Image hash "%s" in "%s".`, i, e[i].Image, s)
-			continue
-		}
-		if _, err = f.sql.ExecContext(x, "add", h, e[i].File, e[i].Bot, e[i].ID); err != nil {
-			err = errors.New(`cannot import record "` + strconv.Itoa(i) + `" in "` + s + `": ` + err.Error())
-			break
+	go func() {
+		select {
+		case <-o:
+			y()
+		case <-x.Done():
 		}
+	}()
+	return x, func() {
+		signal.Stop(o)
+		y()
 	}
-	if y(); err != nil {
-		f.sql.Close()
-	}
-	return err
 }
 
 // New returns a new Forwarder instance based on the passed config file path. This function will preform any
@@ -130,72 +147,101 @@ func (f *Forwarder) Import(s string) error {
 //
 // This function allows for specifying the option to clear the database before starting.
 func New(s string, empty bool) (*Forwarder, error) {
-	var c config
-	j, err := os.ReadFile(s)
+	return newForwarder(s, empty)
+}
+
+// NewOffline returns a new Forwarder instance based on the passed config file path
+// that only connects to the database. The bots are not logged in to Telegram, so
+// this can only be used for imports and cannot be started with 'Run'.
+//
+// This function allows for specifying the option to clear the database before starting.
+func NewOffline(s string, empty bool) (*Forwarder, error) {
+	return newForwarder(s, empty, WithOffline())
+}
+func newForwarder(s string, empty bool, o ...Option) (*Forwarder, error) {
+	c, err := LoadConfig(s)
 	if err != nil {
-		return nil, errors.New(`reading config "` + s + `" failed: ` + err.Error())
+		return nil, err
 	}
-	if err = json.Unmarshal(j, &c); err != nil {
-		return nil, errors.New(`parsing config "` + s + `" failed: ` + err.Error())
+	if o = append(o, withFile(s)); empty {
+		o = append(o, WithClearDatabase())
 	}
-	if err = c.check(); err != nil {
+	return NewWithConfig(c, o...)
+}
+
+// NewWithConfig returns a new Forwarder instance based on the supplied Config and
+// Options. The Config is validated, but environment overrides are not applied
+// (use 'LoadConfig' to read a config file with overrides). The database section
+// is ignored when a Storage is set with 'WithStorage'. Once complete, use the
+// 'Run' function to actually start the Forwarder.
+func NewWithConfig(c Config, o ...Option) (*Forwarder, error) {
+	var v options
+	for i := range o {
+		o[i](&v)
+	}
+	if err := c.check(); err != nil {
 		return nil, err
 	}
-	l := logx.Multiple(logx.Console(logx.Level(c.Log.Level)))
-	if len(c.Log.File) > 0 {
-		f, err2 := logx.File(c.Log.File, logx.Append, logx.Level(c.Log.Level))
-		if err2 != nil {
-			return nil, errors.New(`log file "` + c.Log.File + `" creation failed: ` + err2.Error())
+	if v.store == nil {
+		if err := c.checkDatabase(); err != nil {
+			return nil, err
 		}
-		l.Add(f)
-	}
-	z := make([]*container, 0, len(c.Bots))
-	for i := range c.Bots {
-		b, err := telegram.NewBotAPIWithClient(c.Bots[i].Key, "https://api.telegram.org/bot%s/%s", &http.Client{
-			Transport: &http.Transport{
-				Proxy:             http.ProxyFromEnvironment,
-				MaxIdleConns:      256,
-				ForceAttemptHTTP2: false,
-			},
-		})
-		if err != nil {
-			return nil, errors.New("bot " + strconv.Itoa(i) + ": login failed: " + err.Error())
-		}
-		z = append(z, &container{bot: b, key: c.Bots[i].Key, recv: c.Bots[i].Channel, users: c.Bots[i].Users})
 	}
-	if len(z) == 0 {
+	if len(c.Bots) == 0 {
 		return nil, errors.New("no telegram accounts")
 	}
-	d, err := sql.Open(
-		"mysql",
-		c.Database.Username+":"+c.Database.Password+"@"+c.Database.Server+"/"+c.Database.Name+"?multiStatements=true&interpolateParams=true",
-	)
-	if err != nil {
-		return nil, errors.New(`database connection "` + c.Database.Server + `" failed: ` + err.Error())
+	l := v.log
+	if l == nil {
+		var err error
+		if l, err = newLog(c.Log); err != nil {
+			return nil, err
+		}
 	}
-	if err = d.Ping(); err != nil {
-		return nil, errors.New(`database connection "` + c.Database.Server + `" failed: ` + err.Error())
+	if v.dial == nil {
+		h := v.client
+		v.dial = func(b BotConfig) (Client, error) { return NewClient(b, h) }
 	}
-	m := mapper.New(d)
-	if d.SetConnMaxLifetime(c.Database.Timeout); empty {
-		if err = m.Batch(cleanStatements); err != nil {
-			m.Close()
-			return nil, errors.New("clean up failed: " + err.Error())
+	var z []*container
+	for i := 0; !v.offline && i < len(c.Bots); i++ {
+		b, err := newContainer(i, c.Bots[i], v.dial)
+		if err != nil {
+			return nil, err
+		}
+		z = append(z, b)
+	}
+	d := v.store
+	if d == nil {
+		var err error
+		if d, err = openMySQL(c.Database, v.empty); err != nil {
+			return nil, err
 		}
 	}
-	if err = m.Batch(setupStatements); err != nil {
-		m.Close()
-		return nil, errors.New("database schema setup failed: " + err.Error())
-	}
-	if err = m.Extend(queryStatements); err != nil {
-		m.Close()
-		return nil, errors.New("database schema extend failed: " + err.Error())
-	}
-	return &Forwarder{
-		sql:    m,
-		log:    l,
-		bots:   z,
-		caps:   maps[int64]{v: make(map[int64]caption)},
-		groups: maps[string]{v: make(map[string]caption)},
-	}, nil
+	var (
+		w *webhook
+		m = newMetrics()
+	)
+	if len(c.Webhook.URL) > 0 {
+		w = newWebhook(c.Webhook)
+	}
+	f := &Forwarder{
+		sql:     timed{d, m},
+		custom:  v.store != nil,
+		conf:    c,
+		file:    v.file,
+		hook:    w,
+		hooks:   v.hooks,
+		log:     l,
+		bots:    z,
+		jobs:    make(chan job, 16),
+		stats:   m,
+		token:   c.HTTP.Token,
+		dial:    v.dial,
+		reloads: make(chan Config, 1),
+		caps:    maps[int64]{v: make(map[int64]caption)},
+		groups:  maps[string]{v: make(map[string]caption)},
+	}
+	if len(c.HTTP.Listen) > 0 {
+		f.web = newWeb(f, c.HTTP.Listen)
+	}
+	return f, nil
 }
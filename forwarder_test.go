@@ -0,0 +1,179 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	testUser    = 42
+	testChannel = -1001
+)
+
+type testHook struct {
+	BaseHook
+}
+type countClient struct {
+	Client
+	sent atomic.Int32
+}
+
+func (testHook) BeforePost(_ context.Context, s *Submission) error {
+	if strings.Contains(strings.ToLower(s.Caption), "#nsfw") {
+		return errors.New("tagged as nsfw")
+	}
+	s.Caption = strings.ToLower(s.Caption)
+	return nil
+}
+
+// startForwarder runs a Forwarder against the fake Bot API server with an empty
+// in-memory Storage, and stops it when the test ends.
+func startForwarder(t *testing.T, a *fakeAPI, o ...Option) *memory {
+	t.Helper()
+	m := new(memory)
+	f, err := NewWithConfig(Config{
+		Log: LogConfig{Level: 5},
+		Bots: []BotConfig{{
+			Key: fakeToken, API: a.api(), Files: a.fileURL(), Channel: testChannel, Users: []int64{testUser},
+		}},
+	}, append(o, WithStorage(m), WithHTTPClient(a.srv.Client()))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		x, y = context.WithCancel(context.Background())
+		e    = make(chan error, 1)
+	)
+	go func() { e <- f.Run(x) }()
+	t.Cleanup(func() {
+		y()
+		select {
+		case err := <-e:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second * 10):
+			t.Error("timed out waiting for Run to return")
+		}
+	})
+	return m
+}
+func sentTo(k int64) func(url.Values) bool {
+	return func(v url.Values) bool {
+		return v.Get("chat_id") == strconv.FormatInt(k, 10)
+	}
+}
+func replied(s string) func(url.Values) bool {
+	return func(v url.Values) bool {
+		return v.Get("chat_id") == strconv.Itoa(testUser) && strings.HasPrefix(v.Get("text"), s)
+	}
+}
+func TestForwarder(t *testing.T) {
+	a := newFakeAPI(t)
+	a.file("photo1", fakeImage(t, 1))
+	a.file("photo1-copy", fakeImage(t, 1))
+	a.file("photo2", fakeImage(t, 2))
+	m := startForwarder(t, a)
+
+	a.photo(testUser, "photo1", "First #Tag")
+	p := a.expect(t, "sendPhoto", sentTo(testChannel))
+	if p.Get("photo") != "photo1" || p.Get("caption") != "First #Tag" {
+		t.Fatalf("unexpected post %v", p)
+	}
+	a.expect(t, "sendMessage", replied("I've added that image!"))
+
+	a.photo(testUser, "photo1-copy", "Again")
+	a.expect(t, "sendMessage", replied("I've seen that image before."))
+
+	a.photo(7, "photo2", "")
+	a.expect(t, "sendMessage", func(v url.Values) bool { return v.Get("chat_id") == "7" && v.Get("text") == "Sorry, I don't know you." })
+
+	a.photo(testUser, "photo2", "Second")
+	a.expect(t, "sendPhoto", sentTo(testChannel))
+	a.expect(t, "sendMessage", replied("I've added that image!"))
+	if n := m.count(); n != 2 {
+		t.Fatalf("expected 2 records, got %d", n)
+	}
+
+	a.photo(testUser, "photo1", "/delete")
+	d := a.expect(t, "deleteMessage", sentTo(testChannel))
+	if d.Get("message_id") != p.Get("message_id") {
+		t.Fatalf("deleted message %s, expected %s", d.Get("message_id"), p.Get("message_id"))
+	}
+	a.expect(t, "sendMessage", replied("I've removed that image!"))
+	if n := m.count(); n != 1 {
+		t.Fatalf("expected 1 record after the delete, got %d", n)
+	}
+	if n := a.count("sendPhoto"); n != 2 {
+		t.Fatalf("expected 2 posts, got %d", n)
+	}
+}
+func TestForwarderHooks(t *testing.T) {
+	a := newFakeAPI(t)
+	a.file("photo1", fakeImage(t, 1))
+	a.file("photo2", fakeImage(t, 2))
+	m := startForwarder(t, a, WithHooks(testHook{}))
+
+	a.photo(testUser, "photo1", "Spicy #NSFW")
+	a.expect(t, "sendMessage", replied("I'm sorry, but that image was rejected."))
+	if n := a.count("sendPhoto"); n != 0 {
+		t.Fatalf("expected no posts, got %d", n)
+	}
+	if n := m.count(); n != 0 {
+		t.Fatalf("expected the reservation to be released, got %d records", n)
+	}
+
+	a.photo(testUser, "photo2", "Lower #Case")
+	if p := a.expect(t, "sendPhoto", sentTo(testChannel)); p.Get("caption") != "lower #case" {
+		t.Fatalf("expected the caption to be rewritten, got %q", p.Get("caption"))
+	}
+	a.expect(t, "sendMessage", replied("I've added that image!"))
+}
+func (c *countClient) Send(x context.Context, n telegram.Chattable) (telegram.Message, error) {
+	c.sent.Add(1)
+	return c.Client.Send(x, n)
+}
+func TestForwarderClient(t *testing.T) {
+	a := newFakeAPI(t)
+	a.file("photo1", fakeImage(t, 1))
+	var c *countClient
+	startForwarder(t, a, WithClient(func(b BotConfig) (Client, error) {
+		v, err := NewClient(b, a.srv.Client())
+		if err != nil {
+			return nil, err
+		}
+		c = &countClient{Client: v}
+		return c, nil
+	}))
+
+	a.photo(testUser, "photo1", "")
+	a.expect(t, "sendPhoto", sentTo(testChannel))
+	a.expect(t, "sendMessage", replied("I've added that image!"))
+	if n := c.sent.Load(); n == 0 {
+		t.Fatal("expected the post to use the Client")
+	}
+}
@@ -10,6 +10,8 @@ require (
 	github.com/corona10/goimagehash v1.1.0
 	github.com/go-sql-driver/mysql v1.9.2
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	github.com/pelletier/go-toml/v2 v2.4.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,149 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PurpleSec/logx"
+)
+
+const (
+	pollStale   = time.Second * 90
+	pingTimeout = time.Second * 5
+	healthCache = time.Second * 30
+)
+
+type health struct {
+	last    atomic.Int64
+	polled  atomic.Int64
+	lock    sync.Mutex
+	err     error
+	checked time.Time
+	running atomic.Bool
+}
+type botStatus struct {
+	ID         int64      `json:"id"`
+	API        string     `json:"api"`
+	Mode       string     `json:"mode"`
+	Ready      bool       `json:"ready"`
+	Username   string     `json:"username"`
+	Receiving  bool       `json:"receiving"`
+	LastPoll   *time.Time `json:"last_poll,omitempty"`
+	LastUpdate *time.Time `json:"last_update"`
+}
+type readyStatus struct {
+	Bots     []botStatus `json:"bots"`
+	Ready    bool        `json:"ready"`
+	Database string      `json:"database"`
+}
+
+func (h *health) seen() {
+	h.last.Store(time.Now().UnixNano())
+}
+func stamp(v int64) *time.Time {
+	if v == 0 {
+		return nil
+	}
+	t := time.Unix(0, v).UTC()
+	return &t
+}
+
+// ping returns the result of the last 'getMe' check, making a new one if it is
+// older than 'healthCache'. The request is made without holding the lock, so a
+// slow request does not block other readiness checks. Errors are only logged, as
+// they can contain the request URL with the bot token.
+func (c *container) ping(f *Forwarder) error {
+	c.health.lock.Lock()
+	if time.Since(c.health.checked) <= healthCache {
+		err := c.health.err
+		c.health.lock.Unlock()
+		return err
+	}
+	c.health.lock.Unlock()
+	x, y := context.WithTimeout(context.Background(), pingTimeout)
+	_, err := c.bot.Call(x, "getMe", nil)
+	if y(); err != nil {
+		f.event(c.scope(context.Background()), logx.Warning, "Readiness check of the Telegram API failed: %s!", err.Error())
+	}
+	c.health.lock.Lock()
+	c.health.err, c.health.checked = err, time.Now()
+	c.health.lock.Unlock()
+	return err
+}
+
+// receiving returns true if the receiver thread is running and, when polling, the
+// last 'getUpdates' request succeeded within 'pollStale'.
+func (c *container) receiving() bool {
+	if !c.health.running.Load() {
+		return false
+	}
+	if len(c.hookPath()) > 0 {
+		return true
+	}
+	v := c.health.polled.Load()
+	return v > 0 && time.Since(time.Unix(0, v)) < pollStale
+}
+func (c *container) status(f *Forwarder) botStatus {
+	s := botStatus{
+		ID:         c.bot.Self().ID,
+		API:        "ok",
+		Mode:       "polling",
+		Username:   c.bot.Self().UserName,
+		Receiving:  c.receiving(),
+		LastPoll:   stamp(c.health.polled.Load()),
+		LastUpdate: stamp(c.health.last.Load()),
+	}
+	if len(c.hookPath()) > 0 {
+		s.Mode = "webhook"
+	}
+	if c.ping(f) != nil {
+		s.API = "unreachable"
+	}
+	s.Ready = s.Receiving && s.API == "ok"
+	return s
+}
+func (f *Forwarder) serveHealth(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+func (f *Forwarder) serveReady(w http.ResponseWriter, r *http.Request) {
+	l := f.list()
+	s := readyStatus{Ready: true, Database: "ok", Bots: make([]botStatus, 0, len(l))}
+	x, c := context.WithTimeout(r.Context(), time.Second*5)
+	if err := f.sql.Ping(x); err != nil {
+		f.log.Warning("Readiness check of the database failed: %s!", err.Error())
+		s.Ready, s.Database = false, "unreachable"
+	}
+	c()
+	for _, v := range l {
+		b := v.status(f)
+		if s.Bots = append(s.Bots, b); !b.Ready {
+			s.Ready = false
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !s.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import "context"
+
+// Submission is the media sent to a bot by a user, passed to each Hook. Values
+// are filled in as the submission is processed.
+type Submission struct {
+	// Bot is the ID of the bot that received the submission.
+	Bot int64
+	// User is the ID of the user that sent the submission.
+	User int64
+	// FileID is the Telegram File ID of the media.
+	FileID string
+	// Mime is the mime type of the media, which is empty for photos.
+	Mime string
+	// Caption is the caption that will be posted with the media. Hooks called
+	// before the post can change it.
+	Caption string
+	// Hash is the Image (perceptual) hash, set after hashing.
+	Hash uint64
+	// File is the SHA512 file hash, set after hashing.
+	File string
+	// Message is the ID of the Channel Message, set after posting. For deletes,
+	// this is the Message of the record being deleted.
+	Message int
+}
+
+// Hook is an interface that is called at fixed points while a submission is
+// processed. Returning an error from any function rejects the submission (or
+// cancels the delete for 'OnDelete') and the error is logged. Hooks called
+// before the post can change the Caption of the Submission.
+//
+// Embed 'BaseHook' to only implement some of the functions. Hooks are added
+// with the 'WithHooks' Option and are called in the order they were added.
+type Hook interface {
+	// BeforeHash is called before the media is downloaded and hashed.
+	BeforeHash(x context.Context, s *Submission) error
+	// AfterHash is called once the Image hashes are known, before checking for
+	// duplicates. This is not called for videos or animations.
+	AfterHash(x context.Context, s *Submission) error
+	// BeforePost is called before the media is posted to the Channel.
+	BeforePost(x context.Context, s *Submission) error
+	// AfterPost is called after an Image is posted to the Channel. Rejecting the
+	// submission here removes the post. This is not called for videos or
+	// animations, as they are posted in the background.
+	AfterPost(x context.Context, s *Submission) error
+	// OnDuplicate is called when the Image is already in the Channel.
+	OnDuplicate(x context.Context, s *Submission) error
+	// OnDelete is called before an Image is deleted.
+	OnDelete(x context.Context, s *Submission) error
+}
+
+// BaseHook is a Hook that does nothing, which can be embedded in a struct to
+// only implement some of the Hook functions.
+type BaseHook struct{}
+type hooks []Hook
+
+// WithHooks is an Option that adds Hooks to be called while processing
+// submissions.
+func WithHooks(h ...Hook) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, h...)
+	}
+}
+
+// BeforeHash implements the Hook interface.
+func (BaseHook) BeforeHash(_ context.Context, _ *Submission) error {
+	return nil
+}
+
+// AfterHash implements the Hook interface.
+func (BaseHook) AfterHash(_ context.Context, _ *Submission) error {
+	return nil
+}
+
+// BeforePost implements the Hook interface.
+func (BaseHook) BeforePost(_ context.Context, _ *Submission) error {
+	return nil
+}
+
+// AfterPost implements the Hook interface.
+func (BaseHook) AfterPost(_ context.Context, _ *Submission) error {
+	return nil
+}
+
+// OnDuplicate implements the Hook interface.
+func (BaseHook) OnDuplicate(_ context.Context, _ *Submission) error {
+	return nil
+}
+
+// OnDelete implements the Hook interface.
+func (BaseHook) OnDelete(_ context.Context, _ *Submission) error {
+	return nil
+}
+
+// call runs the function for each Hook in order and returns the first error.
+func (h hooks) call(x context.Context, s *Submission, f func(Hook, context.Context, *Submission) error) error {
+	for i := range h {
+		if err := f(h[i], x, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
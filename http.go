@@ -0,0 +1,72 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// web is the local HTTP listener used for the metrics, health and admin endpoints.
+// This is separate from the Webhook listener, which is exposed to Telegram.
+type web struct {
+	srv   *http.Server
+	mux   *http.ServeMux
+	ready bool
+}
+
+func newWeb(f *Forwarder, a string) *web {
+	w := &web{mux: http.NewServeMux()}
+	w.srv = &http.Server{
+		Addr:              a,
+		Handler:           w.mux,
+		ReadTimeout:       time.Second * 30,
+		WriteTimeout:      time.Second * 30,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	w.mux.HandleFunc("/readyz", f.serveReady)
+	w.mux.HandleFunc("/healthz", f.serveHealth)
+	w.mux.HandleFunc("/metrics", f.serveMetrics)
+	if len(f.token) > 0 {
+		w.mux.HandleFunc("/api/", f.serveAdmin)
+	}
+	return w
+}
+func (w *web) shutdown() error {
+	if !w.ready {
+		return nil
+	}
+	x, c := context.WithTimeout(context.Background(), time.Second*10)
+	err := w.srv.Shutdown(x)
+	c()
+	return err
+}
+func (w *web) listen(f *Forwarder) error {
+	l, err := net.Listen("tcp", w.srv.Addr)
+	if err != nil {
+		return err
+	}
+	w.ready = true
+	go func() {
+		if err := w.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			f.log.Error("HTTP listener stopped: %s!", err.Error())
+		}
+	}()
+	return nil
+}
@@ -17,6 +17,7 @@
 package forwarder
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha512"
 	"encoding/hex"
@@ -27,15 +28,26 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
-	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/PurpleSec/logx"
 	"github.com/corona10/goimagehash"
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-var errNotImage = errors.New("not an image")
+// maxFileSize is the default limit of the size of files downloaded for hashing,
+// which matches the Telegram Bot API download limit.
+const maxFileSize = 20 << 20
+
+var (
+	errNotImage  = errors.New("not an image")
+	errTooLarge  = errors.New("file is larger than the max_file_size limit")
+	errNoScratch = errors.New("scratch_chat_id is not set")
+)
 
 type reader struct {
 	h hash.Hash
@@ -45,6 +57,8 @@ type fileData struct {
 	Sum     string
 	FileID  string
 	Average uint64
+
+	data []byte
 }
 
 func fnv(n string) uint32 {
@@ -72,41 +86,129 @@ func (r *reader) Read(b []byte) (int, error) {
 func (fileData) UploadData() (string, io.Reader, error) {
 	return "", nil, os.ErrInvalid
 }
-func loadImage(x context.Context, bot *telegram.BotAPI, id string, mime string) (fileData, error) {
+func (c *container) maxSize() int64 {
+	c.lock.RLock()
+	n := c.limit
+	c.lock.RUnlock()
+	return n
+}
+func (c *container) open(x context.Context, id string) (io.ReadCloser, error) {
+	f, err := c.bot.GetFile(x, id)
+	if err != nil {
+		return nil, err
+	}
+	// A local Bot API server (running with "--local") returns the absolute path
+	// of the file on disk instead of a download path.
+	if filepath.IsAbs(f.FilePath) {
+		return os.Open(f.FilePath)
+	}
+	c.lock.RLock()
+	u := fmt.Sprintf(c.files, c.key, f.FilePath)
+	c.lock.RUnlock()
+	return c.bot.DownloadFile(x, u)
+}
+
+// fetch resolves the media contained in a Channel Message. The Bot API cannot
+// read Channel history, so the Message is forwarded to the scratch chat of the
+// bot and the copy is removed afterwards. This returns 'errNoScratch' if the
+// bot has no scratch chat, as forwarding to the Channel or a user would show
+// the Message to them.
+func (c *container) fetch(x context.Context, f *Forwarder, m int) (string, string, error) {
+	c.lock.RLock()
+	k := c.scratch
+	c.lock.RUnlock()
+	if k == 0 {
+		return "", "", errNoScratch
+	}
+	v := telegram.NewForward(k, c.channel(), m)
+	v.DisableNotification = true
+	n, err := c.sendMessage(x, f, v)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = c.call(x, f, telegram.NewDeleteMessage(k, n.MessageID)); err != nil {
+		f.event(x, logx.Warning, `Could not remove the forwarded copy "%d": %s!`, n.MessageID, err.Error())
+	}
+	i, t := getTarget(&n)
+	if len(i) == 0 {
+		return "", "", errors.New("message has no media")
+	}
+	return i, t, nil
+}
+func loadImage(x context.Context, f *Forwarder, c *container, id string, mime string) (fileData, error) {
+	e := entryOf(x)
+	if e != nil {
+		e.File = id
+	}
 	if len(mime) > 0 && !strings.HasPrefix(mime, "image/") {
 		return fileData{FileID: id}, errNotImage
 	}
-	f, err := bot.GetFile(telegram.FileConfig{FileID: id})
+	t := time.Now()
+	b, err := c.open(x, id)
 	if err != nil {
 		return fileData{}, err
 	}
+	d, s, err := readImage(b, c.maxSize())
+	if err != nil {
+		return fileData{}, err
+	}
+	l := c.label()
+	f.stats.observe("forwarder_download_seconds", l, time.Since(t))
+	t = time.Now()
+	h, err := hashImage(d, mime)
+	if err != nil {
+		return fileData{FileID: id}, err
+	}
+	if f.stats.observe("forwarder_hash_seconds", l, time.Since(t)); e != nil {
+		e.Hash = strconv.FormatUint(h, 16)
+	}
+	r := fileData{Sum: s, FileID: id, Average: h}
+	if len(c.archiveDir()) > 0 {
+		r.data = d
+	}
+	return r, nil
+}
+
+// readImage reads all the data from the supplied reader and closes it. The data
+// is returned with the hex encoded SHA512 file hash of it. If 'n' is more than
+// zero, data larger than 'n' bytes returns 'errTooLarge'.
+func readImage(b io.ReadCloser, n int64) ([]byte, string, error) {
 	var (
-		z, _ = http.NewRequestWithContext(x, "GET", fmt.Sprintf(telegram.FileEndpoint, bot.Token, f.FilePath), nil)
-		d    *http.Response
+		r = reader{h: sha512.New(), r: b}
+		v = io.Reader(&r)
 	)
-	if d, err = bot.Client.Do(z); err != nil {
-		return fileData{}, err
+	if n > 0 {
+		v = io.LimitReader(v, n+1)
+	}
+	d, err := io.ReadAll(v)
+	if b.Close(); err != nil {
+		return nil, "", err
 	}
+	if n > 0 && int64(len(d)) > n {
+		return nil, "", errTooLarge
+	}
+	return d, hex.EncodeToString(r.h.Sum(nil)), nil
+}
+
+// hashImage decodes the image data based on the mime type and returns the
+// perceptual hash of it. This returns 'errNotImage' if the data cannot be decoded.
+func hashImage(d []byte, mime string) (uint64, error) {
 	var (
-		r = reader{h: sha512.New(), r: d.Body}
-		i image.Image
+		i   image.Image
+		err error
 	)
 	switch mime {
 	case "image/png":
-		i, err = png.Decode(&r)
+		i, err = png.Decode(bytes.NewReader(d))
 	default:
-		i, err = jpeg.Decode(&r)
+		i, err = jpeg.Decode(bytes.NewReader(d))
 	}
-	if d.Body.Close(); err != nil {
-		return fileData{FileID: id}, errNotImage
+	if err != nil {
+		return 0, errNotImage
 	}
 	h, err := goimagehash.PerceptionHash(i)
 	if err != nil {
-		return fileData{}, err
+		return 0, err
 	}
-	return fileData{
-		Sum:     hex.EncodeToString(r.h.Sum(nil)),
-		FileID:  id,
-		Average: h.GetHash(),
-	}, nil
+	return h.GetHash(), nil
 }
@@ -0,0 +1,228 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// importBatch is the number of records inserted in each import transaction.
+const importBatch = 500
+
+// checkpoint is saved next to an import file after each committed batch, so an
+// interrupted import can resume. The file size is used to detect if the import
+// file was changed.
+type checkpoint struct {
+	Size    int64 `json:"size"`
+	Records int   `json:"records"`
+}
+
+// progress tracks the position in an import file to log the progress and ETA.
+type progress struct {
+	start time.Time
+	size  int64
+	from  int64
+	last  time.Time
+}
+
+// Import will attempt to import the data contained in the supplied filepath as
+// a JSON export using the "import.py" tool.
+//
+// The file is read as a stream and the records are inserted in batches. After
+// each batch, a checkpoint file (the path with ".checkpoint" added) is saved. If
+// the import is interrupted, running it again resumes after the last batch.
+func (f *Forwarder) Import(s string) error {
+	x, y := interruptible()
+	err := f.load(x, s)
+	if y(); err != nil {
+		f.sql.Close()
+	}
+	return err
+}
+func (i imported) hash() (uint64, bool) {
+	if i.Bot == 0 || i.ID == 0 || !validSum(i.File) || len(i.Image) == 0 {
+		return 0, false
+	}
+	h, err := strconv.ParseUint(i.Image, 16, 64)
+	return h, err == nil && h > 0
+}
+func readCheckpoint(k string, n int64) checkpoint {
+	var c checkpoint
+	b, err := os.ReadFile(k)
+	if err != nil {
+		return c
+	}
+	if json.Unmarshal(b, &c) != nil || c.Size != n {
+		return checkpoint{}
+	}
+	return c
+}
+func writeCheckpoint(k string, c any) error {
+	b, _ := json.Marshal(c)
+	if err := os.WriteFile(k+".tmp", b, 0640); err != nil {
+		return err
+	}
+	return os.Rename(k+".tmp", k)
+}
+func (p *progress) report(f *Forwarder, n, a int, o int64) {
+	if time.Since(p.last) < time.Second*5 {
+		return
+	}
+	p.last = time.Now()
+	d := o - p.from
+	if d <= 0 || p.size <= 0 {
+		f.log.Info("Processed %d records, %d added..", n, a)
+		return
+	}
+	e := time.Duration(float64(time.Since(p.start)) / float64(d) * float64(p.size-o)).Round(time.Second)
+	f.log.Info("Processed %d records, %d added (%.1f%%, ETA %s)..", n, a, float64(o)/float64(p.size)*100, e)
+}
+
+// openImport opens the import file and returns a decoder positioned at the first
+// record, along with the file size.
+func openImport(s string) (*os.File, *json.Decoder, int64, error) {
+	v, err := os.Open(s)
+	if err != nil {
+		return nil, nil, 0, errors.New(`cannot open "` + s + `": ` + err.Error())
+	}
+	i, err := v.Stat()
+	if err != nil {
+		v.Close()
+		return nil, nil, 0, errors.New(`cannot open "` + s + `": ` + err.Error())
+	}
+	d := json.NewDecoder(v)
+	if t, err := d.Token(); err != nil || t != json.Delim('[') {
+		v.Close()
+		return nil, nil, 0, errors.New(`cannot parse "` + s + `": expected a JSON array`)
+	}
+	return v, d, i.Size(), nil
+}
+func (f *Forwarder) load(x context.Context, s string) error {
+	f.log.Info(`Attempting to import Messages from file "%s"..`, s)
+	v, d, l, err := openImport(s)
+	if err != nil {
+		return err
+	}
+	defer v.Close()
+	var (
+		k = s + ".checkpoint"
+		c = readCheckpoint(k, l)
+	)
+	if c.Records > 0 {
+		f.log.Info(`Resuming the import of "%s" after record "%d"..`, s, c.Records)
+	}
+	var (
+		b    = make([]imported, 0, importBatch)
+		p    = progress{start: time.Now(), size: l, last: time.Now()}
+		n, a int
+	)
+	for d.More() {
+		if x.Err() != nil {
+			return errors.New(`import of "` + s + `" interrupted after record "` + strconv.Itoa(c.Records) + `", run it again to resume`)
+		}
+		var r imported
+		if err = d.Decode(&r); err != nil {
+			return errors.New(`cannot parse record "` + strconv.Itoa(n) + `" in "` + s + `": ` + err.Error())
+		}
+		if n++; n <= c.Records {
+			if n == c.Records {
+				p.start, p.from = time.Now(), d.InputOffset()
+			}
+			continue
+		}
+		if _, ok := r.hash(); !ok {
+			f.log.Warning(`Skipping invalid record at "%d" in "%s".`, n-1, s)
+		} else {
+			b = append(b, r)
+		}
+		if len(b) < importBatch {
+			continue
+		}
+		z, err := f.batch(x, b)
+		if err != nil && x.Err() != nil {
+			return errors.New(`import of "` + s + `" interrupted after record "` + strconv.Itoa(c.Records) + `", run it again to resume`)
+		}
+		if err != nil {
+			return errors.New(`cannot import records before "` + strconv.Itoa(n) + `" in "` + s + `": ` + err.Error())
+		}
+		a, b, c.Size, c.Records = a+z, b[:0], l, n
+		if err = writeCheckpoint(k, c); err != nil {
+			f.log.Warning(`Could not save the import checkpoint "%s": %s!`, k, err.Error())
+		}
+		p.report(f, n, a, d.InputOffset())
+	}
+	if len(b) > 0 {
+		z, err := f.batch(x, b)
+		if err != nil {
+			return errors.New(`cannot import records before "` + strconv.Itoa(n) + `" in "` + s + `": ` + err.Error())
+		}
+		a += z
+	}
+	if err = os.Remove(k); err != nil && !os.IsNotExist(err) {
+		f.log.Warning(`Could not remove the import checkpoint "%s": %s!`, k, err.Error())
+	}
+	f.log.Info(`Import of "%s" complete, %d records processed and %d added in %s.`, s, n, a, time.Since(p.start).Round(time.Second))
+	return nil
+}
+
+// insert adds the records in batches, without using a checkpoint file.
+func (f *Forwarder) insert(x context.Context, s string, e []imported) error {
+	var a int
+	for i := 0; i < len(e); i += importBatch {
+		n, err := f.batch(x, e[i:min(i+importBatch, len(e))])
+		if err != nil {
+			return errors.New(`cannot import records from "` + s + `": ` + err.Error())
+		}
+		a += n
+	}
+	f.log.Info(`Import of "%s" complete, %d records processed and %d added.`, s, len(e), a)
+	return nil
+}
+
+// batch inserts the records in a single transaction and returns the number of
+// records added. Records with an Image hash that already exists for the bot,
+// either in the database or earlier in the batch, are skipped.
+func (f *Forwarder) batch(x context.Context, e []imported) (int, error) {
+	var (
+		v = make([]Image, 0, len(e))
+		u = make(map[[2]uint64]struct{}, len(e))
+	)
+	for i := range e {
+		h, ok := e[i].hash()
+		if !ok {
+			continue
+		}
+		if _, ok = u[[2]uint64{e[i].Bot, h}]; ok {
+			continue
+		}
+		u[[2]uint64{e[i].Bot, h}] = struct{}{}
+		v = append(v, Image{Bot: int64(e[i].Bot), Hash: h, File: e[i].File, Message: e[i].ID})
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	// Block new reservations while the batch is running, so a live submission
+	// cannot add the same Image between the check and the insert.
+	f.ingest.Lock()
+	defer f.ingest.Unlock()
+	return f.sql.Insert(x, v)
+}
@@ -0,0 +1,274 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PurpleSec/logx"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+type entryKey struct{}
+
+// entry is the set of fields attached to log records written while handling
+// an update. The ID is a correlation ID used to follow a single submission from
+// the receiver, through processing and the sender.
+type entry struct {
+	ID       string
+	Bot      int64
+	User     int64
+	File     string
+	Hash     string
+	Outcome  string
+	Duration time.Duration
+
+	start time.Time
+}
+
+// tagged wraps a Chattable with the correlation ID of the update that created it.
+type tagged struct {
+	telegram.Chattable
+	id string
+}
+
+// jsonLog is a logx.Log that writes each record as a single line JSON object.
+// The level can be changed by a reload while records are written, so it is kept
+// in an atomic value.
+type jsonLog struct {
+	w     io.Writer
+	file  *os.File
+	lock  sync.Mutex
+	level atomic.Int32
+	print logx.Level
+}
+type jsonRecord struct {
+	Time     string   `json:"time"`
+	Level    string   `json:"level"`
+	Message  string   `json:"msg"`
+	ID       string   `json:"correlation_id,omitempty"`
+	Bot      int64    `json:"bot_id,omitempty"`
+	User     int64    `json:"user_id,omitempty"`
+	File     string   `json:"file_id,omitempty"`
+	Hash     string   `json:"hash,omitempty"`
+	Outcome  string   `json:"outcome,omitempty"`
+	Duration *float64 `json:"duration,omitempty"`
+}
+
+func levelName(l logx.Level) string {
+	switch l {
+	case logx.Trace:
+		return "trace"
+	case logx.Debug:
+		return "debug"
+	case logx.Info:
+		return "info"
+	case logx.Warning:
+		return "warning"
+	case logx.Error:
+		return "error"
+	case logx.Fatal:
+		return "fatal"
+	}
+	return "panic"
+}
+func entryOf(x context.Context) *entry {
+	e, _ := x.Value(entryKey{}).(*entry)
+	return e
+}
+func withEntry(x context.Context, e *entry) context.Context {
+	return context.WithValue(x, entryKey{}, e)
+}
+func newJSONLog(w io.Writer, l logx.Level) *jsonLog {
+	j := &jsonLog{w: w, print: logx.Info}
+	j.level.Store(int32(l))
+	return j
+}
+
+// newLog creates the Forwarder log, which writes to the console and the optional
+// log file in the configured format.
+func newLog(c LogConfig) (logx.Log, error) {
+	if c.Format == formatJSON {
+		if len(c.File) == 0 {
+			return newJSONLog(logx.DefaultConsole, logx.Level(c.Level)), nil
+		}
+		w, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.New(`log file "` + c.File + `" creation failed: ` + err.Error())
+		}
+		j := newJSONLog(io.MultiWriter(logx.DefaultConsole, w), logx.Level(c.Level))
+		j.file = w
+		return j, nil
+	}
+	l := logx.Multiple(logx.Console(logx.Level(c.Level)))
+	if len(c.File) > 0 {
+		f, err := logx.File(c.File, logx.Append, logx.Level(c.Level))
+		if err != nil {
+			return nil, errors.New(`log file "` + c.File + `" creation failed: ` + err.Error())
+		}
+		l.Add(f)
+	}
+	return l, nil
+}
+
+// scope returns a context that tags log records with the ID of this bot.
+func (c *container) scope(x context.Context) context.Context {
+	return withEntry(x, &entry{Bot: c.bot.Self().ID})
+}
+
+// trace returns a context with a new entry for an update received from the user,
+// containing a new correlation ID.
+func (c *container) trace(x context.Context, u int64) (context.Context, *entry) {
+	e := &entry{ID: randomHex(8), Bot: c.bot.Self().ID, User: u, start: time.Now()}
+	return withEntry(x, e), e
+}
+func (e *entry) finish(o string) {
+	e.Outcome, e.Duration = o, time.Since(e.start)
+}
+func (e *entry) tag(n telegram.Chattable) telegram.Chattable {
+	if e == nil || len(e.ID) == 0 {
+		return n
+	}
+	return tagged{Chattable: n, id: e.ID}
+}
+
+// event writes a log record at the supplied level with the fields of the entry
+// attached to the context. When the Forwarder log is not structured, the bot ID
+// and correlation ID are added to the start of the message instead.
+func (f *Forwarder) event(x context.Context, l logx.Level, m string, v ...any) {
+	e := entryOf(x)
+	if j, ok := f.log.(*jsonLog); ok {
+		j.write(l, e, fmt.Sprintf(m, v...))
+		return
+	}
+	switch {
+	case e == nil:
+	case len(e.ID) > 0:
+		m, v = "[bot %d/%s]: "+m, append([]any{e.Bot, e.ID}, v...)
+	default:
+		m, v = "[bot %d]: "+m, append([]any{e.Bot}, v...)
+	}
+	switch l {
+	case logx.Trace:
+		f.log.Trace(m, v...)
+	case logx.Debug:
+		f.log.Debug(m, v...)
+	case logx.Info:
+		f.log.Info(m, v...)
+	case logx.Warning:
+		f.log.Warning(m, v...)
+	case logx.Error:
+		f.log.Error(m, v...)
+	default:
+		f.log.Fatal(m, v...)
+	}
+}
+func (j *jsonLog) write(l logx.Level, e *entry, m string) {
+	if int32(l) < j.level.Load() {
+		return
+	}
+	r := jsonRecord{Time: time.Now().UTC().Format(time.RFC3339Nano), Level: levelName(l), Message: m}
+	if e != nil {
+		r.ID, r.Bot, r.User, r.File, r.Hash, r.Outcome = e.ID, e.Bot, e.User, e.File, e.Hash, e.Outcome
+		if e.Duration > 0 {
+			d := e.Duration.Seconds()
+			r.Duration = &d
+		}
+	}
+	b, _ := json.Marshal(r)
+	j.lock.Lock()
+	j.w.Write(append(b, '\n'))
+	j.lock.Unlock()
+	if l == logx.Fatal && logx.FatalExits {
+		os.Exit(1)
+	}
+}
+func (j *jsonLog) SetLevel(l logx.Level) {
+	j.level.Store(int32(l))
+}
+
+// Close closes the log file, if there is one. Records written after are only
+// written to the console.
+func (j *jsonLog) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	j.lock.Lock()
+	err := j.file.Close()
+	j.w, j.file = logx.DefaultConsole, nil
+	j.lock.Unlock()
+	return err
+}
+func (*jsonLog) SetPrefix(_ string) {}
+func (j *jsonLog) SetPrintLevel(l logx.Level) {
+	j.print = l
+}
+func (j *jsonLog) Print(v ...any) {
+	j.write(j.print, nil, fmt.Sprint(v...))
+}
+func (j *jsonLog) Panic(v ...any) {
+	s := fmt.Sprint(v...)
+	j.write(logx.Panic, nil, s)
+	panic(s)
+}
+func (j *jsonLog) Println(v ...any) {
+	j.write(j.print, nil, fmt.Sprint(v...))
+}
+func (j *jsonLog) Panicln(v ...any) {
+	s := fmt.Sprint(v...)
+	j.write(logx.Panic, nil, s)
+	panic(s)
+}
+func (j *jsonLog) Info(m string, v ...any) {
+	j.write(logx.Info, nil, fmt.Sprintf(m, v...))
+}
+func (j *jsonLog) Error(m string, v ...any) {
+	j.write(logx.Error, nil, fmt.Sprintf(m, v...))
+}
+func (j *jsonLog) Fatal(m string, v ...any) {
+	j.write(logx.Fatal, nil, fmt.Sprintf(m, v...))
+}
+func (j *jsonLog) Trace(m string, v ...any) {
+	j.write(logx.Trace, nil, fmt.Sprintf(m, v...))
+}
+func (j *jsonLog) Debug(m string, v ...any) {
+	j.write(logx.Debug, nil, fmt.Sprintf(m, v...))
+}
+func (j *jsonLog) Printf(m string, v ...any) {
+	j.write(j.print, nil, fmt.Sprintf(m, v...))
+}
+func (j *jsonLog) Panicf(m string, v ...any) {
+	s := fmt.Sprintf(m, v...)
+	j.write(logx.Panic, nil, s)
+	panic(s)
+}
+func (j *jsonLog) Warning(m string, v ...any) {
+	j.write(logx.Warning, nil, fmt.Sprintf(m, v...))
+}
@@ -0,0 +1,268 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kindCounter   = "counter"
+	kindHistogram = "histogram"
+)
+
+var buckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics is a minimal Prometheus text format registry. Counters and histograms
+// are tracked here, while gauges are computed when scraped.
+type metrics struct {
+	lock sync.Mutex
+	fams map[string]*family
+}
+type series struct {
+	sum    float64
+	count  uint64
+	counts []uint64
+}
+type family struct {
+	name   string
+	help   string
+	kind   string
+	values map[string]*series
+}
+
+func newMetrics() *metrics {
+	m := &metrics{fams: make(map[string]*family)}
+	m.define("forwarder_add_total", kindCounter, "Image and media submissions by outcome.")
+	m.define("forwarder_delete_total", kindCounter, "Delete requests by outcome.")
+	m.define("forwarder_unauthorized_total", kindCounter, "Messages received from unauthorized users.")
+	m.define("forwarder_download_seconds", kindHistogram, "Time spent downloading submitted files.")
+	m.define("forwarder_hash_seconds", kindHistogram, "Time spent decoding and hashing submitted images.")
+	m.define("forwarder_db_query_seconds", kindHistogram, "Database query latency by statement.")
+	return m
+}
+func labels(v ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(v); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(v[i])
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(v[i+1]))
+	}
+	return b.String()
+}
+func (m *metrics) define(n, k, h string) {
+	m.fams[n] = &family{name: n, kind: k, help: h, values: make(map[string]*series)}
+}
+func (m *metrics) inc(n, l string) {
+	m.lock.Lock()
+	s := m.get(n, l)
+	s.sum++
+	m.lock.Unlock()
+}
+func (m *metrics) write(w io.Writer) {
+	m.lock.Lock()
+	n := make([]string, 0, len(m.fams))
+	for k := range m.fams {
+		n = append(n, k)
+	}
+	sort.Strings(n)
+	for _, k := range n {
+		m.fams[k].write(w)
+	}
+	m.lock.Unlock()
+}
+func (m *metrics) get(n, l string) *series {
+	f := m.fams[n]
+	s, ok := f.values[l]
+	if !ok {
+		s = new(series)
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(buckets))
+		}
+		f.values[l] = s
+	}
+	return s
+}
+func (f *family) write(w io.Writer) {
+	io.WriteString(w, "# HELP "+f.name+" "+f.help+"\n# TYPE "+f.name+" "+f.kind+"\n")
+	k := make([]string, 0, len(f.values))
+	for v := range f.values {
+		k = append(k, v)
+	}
+	sort.Strings(k)
+	for _, l := range k {
+		s := f.values[l]
+		if f.kind == kindCounter {
+			io.WriteString(w, sample(f.name, l)+formatFloat(s.sum)+"\n")
+			continue
+		}
+		p := l
+		if len(p) > 0 {
+			p += ","
+		}
+		for i := range buckets {
+			io.WriteString(w, f.name+"_bucket{"+p+`le="`+formatFloat(buckets[i])+`"} `+strconv.FormatUint(s.counts[i], 10)+"\n")
+		}
+		io.WriteString(w, f.name+"_bucket{"+p+`le="+Inf"} `+strconv.FormatUint(s.count, 10)+"\n")
+		io.WriteString(w, sample(f.name+"_sum", l)+formatFloat(s.sum)+"\n")
+		io.WriteString(w, sample(f.name+"_count", l)+strconv.FormatUint(s.count, 10)+"\n")
+	}
+}
+func sample(n, l string) string {
+	if len(l) == 0 {
+		return n + " "
+	}
+	return n + "{" + l + "} "
+}
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+func gauge(w io.Writer, n, h string, v map[string]int) {
+	io.WriteString(w, "# HELP "+n+" "+h+"\n# TYPE "+n+" gauge\n")
+	k := make([]string, 0, len(v))
+	for l := range v {
+		k = append(k, l)
+	}
+	sort.Strings(k)
+	for _, l := range k {
+		io.WriteString(w, sample(n, l)+strconv.Itoa(v[l])+"\n")
+	}
+}
+func (m *metrics) observe(n, l string, d time.Duration) {
+	v := d.Seconds()
+	m.lock.Lock()
+	s := m.get(n, l)
+	for i := range buckets {
+		if v <= buckets[i] {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	m.lock.Unlock()
+}
+
+// timed is a Storage that records the latency of each function of the wrapped
+// Storage in the metrics.
+type timed struct {
+	Storage
+	m *metrics
+}
+
+func (t timed) since(n string, v time.Time) {
+	t.m.observe("forwarder_db_query_seconds", labels("query", n), time.Since(v))
+}
+func (t timed) Get(x context.Context, id uint64) (Image, bool, error) {
+	defer t.since("get", time.Now())
+	return t.Storage.Get(x, id)
+}
+func (t timed) List(x context.Context, bot int64, f func(Image) error) error {
+	defer t.since("list", time.Now())
+	return t.Storage.List(x, bot, f)
+}
+func (t timed) Sample(x context.Context, bot int64, n int) ([]Image, error) {
+	defer t.since("sample", time.Now())
+	return t.Storage.Sample(x, bot, n)
+}
+func (t timed) FindFile(x context.Context, bot int64, file string) ([]Image, error) {
+	defer t.since("find_file", time.Now())
+	return t.Storage.FindFile(x, bot, file)
+}
+func (t timed) FindHash(x context.Context, bot int64, hash uint64) ([]Image, error) {
+	defer t.since("find_hash", time.Now())
+	return t.Storage.FindHash(x, bot, hash)
+}
+func (t timed) FindMessage(x context.Context, bot int64, message uint64) ([]Image, error) {
+	defer t.since("find_message", time.Now())
+	return t.Storage.FindMessage(x, bot, message)
+}
+func (t timed) Reserve(x context.Context, bot int64, hash uint64, file string) (uint64, bool, error) {
+	defer t.since("reserve", time.Now())
+	return t.Storage.Reserve(x, bot, hash, file)
+}
+func (t timed) Bind(x context.Context, id, message uint64) (bool, error) {
+	defer t.since("bind", time.Now())
+	return t.Storage.Bind(x, id, message)
+}
+func (t timed) Release(x context.Context, id uint64) error {
+	defer t.since("release", time.Now())
+	return t.Storage.Release(x, id)
+}
+func (t timed) ReleaseAll(x context.Context, bot int64) (int, error) {
+	defer t.since("release_all", time.Now())
+	return t.Storage.ReleaseAll(x, bot)
+}
+func (t timed) Update(x context.Context, id, hash uint64, file string) error {
+	defer t.since("update", time.Now())
+	return t.Storage.Update(x, id, hash, file)
+}
+func (t timed) Remove(x context.Context, id uint64) error {
+	defer t.since("remove", time.Now())
+	return t.Storage.Remove(x, id)
+}
+func (t timed) Delete(x context.Context, bot int64, file string) (uint64, error) {
+	defer t.since("delete", time.Now())
+	return t.Storage.Delete(x, bot, file)
+}
+func (t timed) Insert(x context.Context, e []Image) (int, error) {
+	defer t.since("insert", time.Now())
+	return t.Storage.Insert(x, e)
+}
+func (t timed) Enqueue(x context.Context, bot int64, v Task) (uint64, error) {
+	defer t.since("enqueue", time.Now())
+	return t.Storage.Enqueue(x, bot, v)
+}
+func (t timed) Dequeue(x context.Context, id uint64) error {
+	defer t.since("dequeue", time.Now())
+	return t.Storage.Dequeue(x, id)
+}
+func (t timed) Pending(x context.Context, bot int64) ([]Task, error) {
+	defer t.since("pending", time.Now())
+	return t.Storage.Pending(x, bot)
+}
+func (t timed) Users(x context.Context, bot int64) (map[int64]bool, error) {
+	defer t.since("users", time.Now())
+	return t.Storage.Users(x, bot)
+}
+func (t timed) SetUser(x context.Context, bot, user int64, allowed bool) error {
+	defer t.since("set_user", time.Now())
+	return t.Storage.SetUser(x, bot, user, allowed)
+}
+func (f *Forwarder) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	f.stats.write(w)
+	l := f.list()
+	q := make(map[string]int, len(l))
+	for _, c := range l {
+		if n, ok := c.pending(); ok {
+			q[labels("bot", strconv.FormatInt(c.bot.Self().ID, 10))] = n
+		}
+	}
+	gauge(w, "forwarder_queue_depth", "Pending outbound Telegram messages per bot.", q)
+	gauge(w, "forwarder_captions", "Cached captions waiting for media.", map[string]int{"": f.caps.len()})
+	gauge(w, "forwarder_groups", "Cached media group captions.", map[string]int{"": f.groups.len()})
+}
@@ -0,0 +1,300 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/PurpleSec/logx"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// migration is the state of a Channel migration, saved after each Message so an
+// interrupted migration can resume. The record IDs are saved in their original
+// order when the migration starts, and the new Message of each record is kept
+// in 'Moved' until every record is posted, so the records are only updated once
+// they all point to the new Channel.
+type migration struct {
+	From    int64          `json:"from_channel_id"`
+	Moved   map[uint64]int `json:"moved"`
+	Channel int64          `json:"channel_id"`
+	Records []uint64       `json:"records"`
+}
+type manifest struct {
+	last  map[string]archived
+	posts map[[2]int64]archived
+}
+
+// Migrate republishes the records of the bot, in their original order, from the
+// current Channel of the bot to the Channel 'k' and rewrites the record Message
+// IDs to the new Messages. If 'b' is zero and there is only one bot, that bot is
+// used. Once complete, the bot "channel_id" should be changed to the new Channel.
+// If 'drop' is true, records that cannot be posted are removed instead of
+// blocking the migration.
+//
+// The Forwarder must not be running while migrating.
+func (f *Forwarder) Migrate(b, k int64, drop bool) error {
+	x, y := interruptible()
+	err := f.migrate(x, b, 0, k, drop)
+	if y(); err != nil {
+		f.sql.Close()
+	}
+	return err
+}
+
+// Restore republishes the records of the bot that point to Messages in the old
+// Channel 'k' (such as a deleted Channel) to the current Channel of the bot, in
+// their original order, and rewrites the record Message IDs to the new Messages.
+// If 'b' is zero and there is only one bot, that bot is used. This is the same
+// as 'Migrate', but is run after "channel_id" has been changed.
+//
+// The Forwarder must not be running while restoring.
+func (f *Forwarder) Restore(b, k int64, drop bool) error {
+	x, y := interruptible()
+	err := f.migrate(x, b, k, 0, drop)
+	if y(); err != nil {
+		f.sql.Close()
+	}
+	return err
+}
+
+// migrate republishes the records of the bot from the Channel 'o' to the Channel
+// 'k'. A zero Channel is the current Channel of the bot.
+//
+// The media for each record is taken from the bot archive directory (if the file
+// is archived), the File ID in the archive manifest, or copied from the old
+// Channel, in that order. The progress is saved to a checkpoint file next to the
+// config file and running the same migration again resumes it. Records that
+// could not be posted are retried by the next run, and the records are only
+// updated once every record has been posted. If 'drop' is true, the records that
+// could not be posted are removed and reported instead, so an unrecoverable
+// record does not block the rest.
+func (f *Forwarder) migrate(x context.Context, b, o, k int64, drop bool) error {
+	var (
+		c *container
+		l = f.list()
+	)
+	for _, v := range l {
+		if v.bot.Self().ID == b || (b == 0 && len(l) == 1) {
+			c = v
+			break
+		}
+	}
+	switch {
+	case c == nil && b == 0:
+		return errors.New("a bot ID is required when there is more than one bot")
+	case c == nil:
+		return errors.New(`bot "` + strconv.FormatInt(b, 10) + `" is not in the config`)
+	case o == 0 && k == 0:
+		return errors.New("a Channel ID is required")
+	case o == 0:
+		o = c.channel()
+	case k == 0:
+		k = c.channel()
+	}
+	if o == k {
+		return errors.New(`bot "` + strconv.FormatInt(c.bot.Self().ID, 10) + `" already posts to Channel "` + strconv.FormatInt(k, 10) + `"`)
+	}
+	var (
+		y    = c.scope(x)
+		p    = filepath.Join(filepath.Dir(f.file), "migrate-"+strconv.FormatInt(c.bot.Self().ID, 10)+".checkpoint")
+		m, s = readMigration(p)
+	)
+	if s != nil {
+		return errors.New(`cannot read the migration checkpoint "` + p + `": ` + s.Error())
+	}
+	switch {
+	case m.Channel != 0 && (m.Channel != k || m.From != o):
+		return errors.New(
+			`a migration from Channel "` + strconv.FormatInt(m.From, 10) + `" to "` + strconv.FormatInt(m.Channel, 10) + `" is in progress, remove "` + p + `" to start over`,
+		)
+	case m.Channel == 0:
+		r, err := f.bound(x, c.bot.Self().ID)
+		if err != nil {
+			return err
+		}
+		m.From, m.Channel, m.Records = o, k, make([]uint64, len(r))
+		for i := range r {
+			m.Records[i] = r[i].ID
+		}
+		if err = writeCheckpoint(p, m); err != nil {
+			return errors.New(`cannot save the migration checkpoint "` + p + `": ` + err.Error())
+		}
+	default:
+		f.event(y, logx.Info, `Resuming the migration to Channel "%d" after %d of %d records..`, k, len(m.Moved), len(m.Records))
+	}
+	if m.Moved == nil {
+		m.Moved = make(map[uint64]int, len(m.Records))
+	}
+	d := c.archiveDir()
+	a, err := loadManifest(d)
+	if err != nil {
+		return errors.New(`cannot read the archive manifest in "` + d + `": ` + err.Error())
+	}
+	f.event(y, logx.Info, `Migrating %d records from Channel "%d" to "%d"..`, len(m.Records)-len(m.Moved), o, k)
+	var (
+		t = time.Now()
+		e = make([]record, 0, len(m.Records))
+		q []record
+		n int
+	)
+	for _, i := range m.Records {
+		if x.Err() != nil {
+			return errors.New(`migration interrupted after ` + strconv.Itoa(len(m.Moved)) + ` records, run it again to resume`)
+		}
+		r, err := f.record(x, i)
+		if err == errNoRecord {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if e = append(e, r); m.Moved[r.ID] > 0 {
+			continue
+		}
+		v, err := c.republish(y, f, r, a.find(o, r), d, o, k)
+		if err != nil {
+			if x.Err() != nil {
+				return errors.New(`migration interrupted after ` + strconv.Itoa(len(m.Moved)) + ` records, run it again to resume`)
+			}
+			f.event(y, logx.Warning, `Could not migrate record "%d" (Message "%d"): %s!`, r.ID, r.Message, err.Error())
+			q = append(q, r)
+			continue
+		}
+		if len(d) > 0 {
+			s, _ := getTarget(&v)
+			if err = writeManifest(d, archived{Sum: r.File, Time: time.Now(), FileID: s, Caption: a.find(o, r).Caption, Channel: k, Message: v.MessageID}); err != nil {
+				f.event(y, logx.Warning, `Could not add Message "%d" to the archive manifest: %s!`, v.MessageID, err.Error())
+			}
+		}
+		f.event(y, logx.Debug, `Posted record "%d" (Message "%d") as Message "%d".`, r.ID, r.Message, v.MessageID)
+		m.Moved[r.ID] = v.MessageID
+		if err = writeCheckpoint(p, m); err != nil {
+			f.event(y, logx.Warning, `Could not save the migration checkpoint "%s": %s!`, p, err.Error())
+		}
+		n++
+	}
+	if len(q) > 0 && !drop {
+		return errors.New(
+			strconv.Itoa(len(q)) + ` records could not be posted to Channel "` + strconv.FormatInt(k, 10) +
+				`", no records were updated, run it again to retry them or add "-drop-failed" to remove them`,
+		)
+	}
+	for _, r := range q {
+		if err = f.sql.Remove(x, r.ID); err != nil {
+			return errors.New(`cannot remove record "` + strconv.FormatUint(r.ID, 10) + `", run it again to resume: ` + err.Error())
+		}
+		f.event(y, logx.Warning, `Removed record "%d" (Message "%d", file "%s") that could not be posted.`, r.ID, r.Message, r.File)
+	}
+	// Every remaining record has been posted, so the records can now be moved to
+	// the new Channel without leaving any pointing to the old one.
+	for _, r := range e {
+		if m.Moved[r.ID] == 0 {
+			continue
+		}
+		if _, err = f.sql.Bind(x, r.ID, uint64(m.Moved[r.ID])); err != nil {
+			return errors.New(`cannot update record "` + strconv.FormatUint(r.ID, 10) + `", run it again to resume: ` + err.Error())
+		}
+	}
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		f.event(y, logx.Warning, `Could not remove the migration checkpoint "%s": %s!`, p, err.Error())
+	}
+	f.event(y, logx.Info, `Migration to Channel "%d" complete, %d Messages posted, %d records updated and %d removed in %s.`, k, n, len(e)-len(q), len(q), time.Since(t).Round(time.Second))
+	if k != c.channel() {
+		f.event(y, logx.Info, `Change "channel_id" to "%d" before starting.`, k)
+	}
+	return nil
+}
+func readMigration(p string) (migration, error) {
+	var m migration
+	b, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// loadManifest reads the archive manifest in the directory, if there is one. A
+// missing directory or manifest returns an empty manifest.
+func loadManifest(d string) (manifest, error) {
+	var a manifest
+	if len(d) == 0 {
+		return a, nil
+	}
+	e, err := readManifest(d)
+	if err != nil && !os.IsNotExist(err) {
+		return a, err
+	}
+	a.last, a.posts = make(map[string]archived, len(e)), make(map[[2]int64]archived, len(e))
+	for _, v := range e {
+		a.last[v.Sum], a.posts[[2]int64{v.Channel, int64(v.Message)}] = v, v
+	}
+	return a, nil
+}
+
+// find returns the manifest entry of the Message the record points to in the
+// Channel 'k'. Message IDs are only unique in a Channel, so the entry is only
+// used if it has the same file hash as the record, otherwise the last entry with
+// the file hash is returned.
+func (a manifest) find(k int64, r record) archived {
+	if v, ok := a.posts[[2]int64{k, int64(r.Message)}]; ok && v.Sum == r.File {
+		return v
+	}
+	return a.last[r.File]
+}
+
+// republish posts the media of the record to the Channel 'k'. The archived file
+// is uploaded if it exists, then the manifest File ID is tried, and the Message
+// is copied from the old Channel 'o' as a last resort.
+func (c *container) republish(x context.Context, f *Forwarder, r record, a archived, d string, o, k int64) (telegram.Message, error) {
+	b := telegram.BaseChat{ChatID: k, DisableNotification: true}
+	if len(d) > 0 {
+		if p := archivePath(d, r.File); len(p) > 0 && isFile(p) {
+			return c.sendMessage(x, f, telegram.PhotoConfig{
+				Caption: a.Caption, ParseMode: "markdown", CaptionEntities: splitTags(a.Caption),
+				BaseFile: telegram.BaseFile{File: telegram.FilePath(p), BaseChat: b},
+			})
+		}
+	}
+	if len(a.FileID) > 0 {
+		v, err := c.sendMessage(x, f, telegram.PhotoConfig{
+			Caption: a.Caption, ParseMode: "markdown", CaptionEntities: splitTags(a.Caption),
+			BaseFile: telegram.BaseFile{File: telegram.FileID(a.FileID), BaseChat: b},
+		})
+		if err == nil || x.Err() != nil {
+			return v, err
+		}
+		f.event(x, logx.Debug, `Archived File ID for record "%d" failed, copying the Message instead: %s!`, r.ID, err.Error())
+	}
+	v := telegram.NewCopyMessage(k, o, int(r.Message))
+	v.DisableNotification = true
+	return c.sendMessage(x, f, v)
+}
+func isFile(p string) bool {
+	i, err := os.Stat(p)
+	return err == nil && i.Mode().IsRegular()
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testNewChannel = -1009
+
+func TestMigrate(t *testing.T) {
+	var (
+		a = newFakeAPI(t)
+		m = new(memory)
+		d = t.TempDir()
+		x = context.Background()
+	)
+	m.Insert(x, []Image{{Bot: 1234, Hash: 1, File: "file1", Message: 10}, {Bot: 1234, Hash: 2, File: "file2", Message: 11}})
+	f, err := NewWithConfig(Config{
+		Log:  LogConfig{Level: 5},
+		Bots: []BotConfig{{Key: fakeToken, API: a.api(), Files: a.fileURL(), Channel: testChannel}},
+	}, WithStorage(m), WithHTTPClient(a.srv.Client()), withFile(filepath.Join(d, "forwarder.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(d, "migrate-1234.checkpoint")
+
+	a.gone["11"] = true
+	if err = f.migrate(x, 0, 0, testNewChannel, false); err == nil {
+		t.Fatal("expected the failed record to block the migration")
+	}
+	if e := messages(t, m, 1234); !equal(e, []uint64{10, 11}) {
+		t.Fatalf("records were updated by a failed migration: %v", e)
+	}
+	if _, err = os.Stat(p); err != nil {
+		t.Fatalf("expected the checkpoint to be kept: %s", err)
+	}
+
+	a.gone["11"] = false
+	if err = f.migrate(x, 0, 0, testNewChannel, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := a.count("copyMessage"); n != 3 {
+		t.Fatalf("expected 3 copies (one failed), got %d", n)
+	}
+	if e := messages(t, m, 1234); !equal(e, []uint64{101, 102}) {
+		t.Fatalf("records were not moved to the new Messages: %v", e)
+	}
+	if _, err = os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("expected the checkpoint to be removed: %v", err)
+	}
+
+	m.Insert(x, []Image{{Bot: 1234, Hash: 3, File: "file3", Message: 12}, {Bot: 1234, Hash: 4, File: "file4", Message: 13}})
+	a.gone["12"] = true
+	if err = f.migrate(x, 0, testNewChannel, testChannel, true); err != nil {
+		t.Fatal(err)
+	}
+	if e := messages(t, m, 1234); !equal(e, []uint64{103, 104, 105}) {
+		t.Fatalf("the failed record was not removed or the rest not updated: %v", e)
+	}
+	if _, err = os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("expected the checkpoint to be removed: %v", err)
+	}
+}
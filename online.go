@@ -0,0 +1,144 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dropInterval is how often the import drop directory is checked.
+	dropInterval = time.Second * 10
+	// dropSettle is how long a file in the drop directory must be unchanged
+	// before it is imported, so partially written files are not read.
+	dropSettle = time.Second * 5
+)
+
+var errQueueFull = errors.New("import queue is full")
+
+// job is an import submitted to a running Forwarder. Jobs are run one at a time
+// by the importer thread, so they do not compete with each other.
+type job struct {
+	path   string
+	done   func(error)
+	export bool
+}
+
+// submit queues the import of the file (or Telegram Desktop export folder, if
+// 'e' is true) to be run by the importer thread.
+func (f *Forwarder) submit(s string, e bool, d func(error)) error {
+	select {
+	case f.jobs <- job{path: s, export: e, done: d}:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+func (f *Forwarder) run(x context.Context, j job) {
+	var err error
+	if j.export {
+		err = f.loadExport(x, j.path)
+	} else {
+		err = f.load(x, j.path)
+	}
+	if err != nil {
+		f.log.Error(`Import of "%s" failed: %s!`, j.path, err.Error())
+	} else {
+		f.log.Info(`Import of "%s" completed.`, j.path)
+	}
+	if j.done != nil {
+		j.done(err)
+	}
+}
+func (f *Forwarder) importer(x context.Context, g *sync.WaitGroup, d string) {
+	var t <-chan time.Time
+	if len(d) > 0 {
+		v := time.NewTicker(dropInterval)
+		defer v.Stop()
+		t = v.C
+		f.log.Info(`Watching "%s" for imports..`, d)
+	}
+	for {
+		select {
+		case j := <-f.jobs:
+			f.run(x, j)
+		case <-t:
+			f.scan(x, d)
+		case <-x.Done():
+			g.Done()
+			return
+		}
+	}
+}
+
+// scan imports any settled files in the drop directory. JSON files are imported
+// as "import.py" files and directories containing a "result.json" file are
+// imported as Telegram Desktop exports. Imported entries are moved to the "done"
+// directory, or the "failed" directory if the import failed.
+func (f *Forwarder) scan(x context.Context, d string) {
+	e, err := os.ReadDir(d)
+	if err != nil {
+		f.log.Warning(`Could not read the import directory "%s": %s!`, d, err.Error())
+		return
+	}
+	for _, v := range e {
+		if x.Err() != nil {
+			return
+		}
+		var (
+			p = filepath.Join(d, v.Name())
+			k = p
+			o bool
+		)
+		switch {
+		case strings.HasPrefix(v.Name(), "."):
+			continue
+		case v.IsDir():
+			if v.Name() == "done" || v.Name() == "failed" {
+				continue
+			}
+			k, o = filepath.Join(p, "result.json"), true
+		case !strings.HasSuffix(v.Name(), ".json"):
+			continue
+		}
+		i, err := os.Stat(k)
+		if err != nil || time.Since(i.ModTime()) < dropSettle {
+			continue
+		}
+		f.run(x, job{path: p, export: o, done: func(err error) {
+			if x.Err() != nil {
+				return
+			}
+			n := "done"
+			if err != nil {
+				n = "failed"
+			}
+			if err = os.MkdirAll(filepath.Join(d, n), 0750); err == nil {
+				err = os.Rename(p, filepath.Join(d, n, v.Name()))
+			}
+			if err != nil {
+				f.log.Warning(`Could not move the import "%s" to "%s": %s!`, p, n, err.Error())
+			}
+		}})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"net/http"
+
+	"github.com/PurpleSec/logx"
+)
+
+// Option is a function that changes how 'NewWithConfig' creates a Forwarder.
+type Option func(*options)
+type options struct {
+	log     logx.Log
+	file    string
+	store   Storage
+	hooks   []Hook
+	empty   bool
+	dial    func(BotConfig) (Client, error)
+	client  *http.Client
+	offline bool
+}
+
+// WithOffline is an Option that only connects to the Storage. The bots are not
+// logged in to Telegram, so the Forwarder can only be used for imports and
+// exports and cannot be started with 'Run'.
+func WithOffline() Option {
+	return func(o *options) {
+		o.offline = true
+	}
+}
+
+// WithClearDatabase is an Option that removes ALL DATA from the default MySQL
+// Storage before it is set up. This has no effect when using 'WithStorage'.
+func WithClearDatabase() Option {
+	return func(o *options) {
+		o.empty = true
+	}
+}
+
+// WithLogger is an Option that sets the logger used instead of the one created
+// from the log section of the Config.
+func WithLogger(l logx.Log) Option {
+	return func(o *options) {
+		o.log = l
+	}
+}
+
+// WithStorage is an Option that sets the Storage used instead of connecting to
+// the MySQL database in the Config. The Storage is closed when 'Run' returns.
+func WithStorage(s Storage) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// WithHTTPClient is an Option that sets the HTTP client used by the bots for all
+// Telegram Bot API requests and file downloads. This has no effect when using
+// 'WithClient'.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+func withFile(s string) Option {
+	return func(o *options) {
+		o.file = s
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+
+	"github.com/PurpleSec/logx"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const actionDelete uint8 = 1
+
+// queued is a Chattable that is backed by a row in the durable Queue table. The
+// row is removed once the sender thread has delivered (or permanently failed)
+// the request.
+type queued struct {
+	telegram.Chattable
+	id uint64
+}
+
+func (f *Forwarder) dequeue(x context.Context, q queued, err error) {
+	if err != nil {
+		// Keep the row around for a later replay unless Telegram rejected it,
+		// as retrying a rejected request will never succeed.
+		var e *telegram.Error
+		if !errors.As(err, &e) || e.Code == 429 {
+			return
+		}
+	}
+	if err = f.sql.Dequeue(x, q.id); err != nil {
+		f.log.Error(`Received an error removing Queue entry "%d": %s!`, q.id, err.Error())
+	}
+}
+func (c *container) release(x context.Context, f *Forwarder, n uint64) {
+	if err := f.sql.Release(x, n); err != nil {
+		f.event(x, logx.Warning, `Could not release the reservation "%d": %s!`, n, err.Error())
+	}
+}
+
+// reconcile releases any Image reservations that were never bound to a Message
+// due to a crash. This must run before the receiver thread is started, as any
+// reservations made by new submissions would be released too.
+func (c *container) reconcile(x context.Context, f *Forwarder) {
+	if n, err := f.sql.ReleaseAll(x, c.bot.Self().ID); err != nil {
+		f.event(x, logx.Error, "Received an error releasing stale reservations: %s!", err.Error())
+	} else if n > 0 {
+		f.event(x, logx.Info, "Released %d stale reservations.", n)
+	}
+}
+
+// replay sends the pending Queue entries of the bot and is only called by the
+// sender thread, so each entry is sent once. Entries in 's' already failed in
+// this run and are kept for the next start instead of being retried each time.
+func (c *container) replay(x context.Context, f *Forwarder, s map[uint64]bool) {
+	t, err := f.sql.Pending(x, c.bot.Self().ID)
+	if err != nil {
+		f.event(x, logx.Error, "Received an error loading the durable Queue: %s!", err.Error())
+		return
+	}
+	for _, v := range t {
+		if s[v.ID] || x.Err() != nil {
+			continue
+		}
+		switch v.Action {
+		case actionDelete:
+			f.event(x, logx.Trace, `Sending Queue entry "%d"..`, v.ID)
+			if !c.deliver(x, f, queued{Chattable: telegram.NewDeleteMessage(v.Chat, int(v.Message)), id: v.ID}) {
+				s[v.ID] = true
+			}
+		default:
+			f.event(x, logx.Warning, `Ignoring unknown Queue action "%d" for entry "%d".`, v.Action, v.ID)
+			s[v.ID] = true
+		}
+	}
+}
+
+// notify wakes the sender thread to send the pending Queue entries. This does
+// not block, as a single pending wake-up sends every entry.
+func (c *container) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue persists the delete of the Message in the durable Queue and wakes the
+// sender thread to send it. If it cannot be persisted, it is sent directly.
+func (c *container) enqueue(x context.Context, f *Forwarder, o chan<- telegram.Chattable, k int64, m int) {
+	_, err := f.sql.Enqueue(x, c.bot.Self().ID, Task{Chat: k, Message: uint64(m), Action: actionDelete})
+	if err != nil {
+		f.event(x, logx.Warning, `Could not persist the delete of Message "%d", sending it anyway: %s!`, m, err.Error())
+		o <- entryOf(x).tag(telegram.NewDeleteMessage(k, m))
+		return
+	}
+	c.notify()
+}
@@ -0,0 +1,184 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/PurpleSec/logx"
+)
+
+func (f *Forwarder) list() []*container {
+	f.lock.RLock()
+	r := f.bots
+	f.lock.RUnlock()
+	return r
+}
+func (c *container) update(b BotConfig) {
+	c.lock.Lock()
+	c.recv, c.files, c.archive, c.limit, c.scratch = b.Channel, b.Files, b.Archive, b.MaxSize, b.Scratch
+	c.users = append(make([]int64, 0, len(b.Users)), b.Users...)
+	c.lock.Unlock()
+}
+
+// LoadConfig reads and validates the config file at the supplied path, applying
+// any environment overrides. The format is detected by the file extension. The
+// database section is checked by 'NewWithConfig', as it is not needed when the
+// Forwarder uses another Storage.
+func LoadConfig(s string) (Config, error) {
+	var c Config
+	j, err := os.ReadFile(s)
+	if err != nil {
+		return c, errors.New(`reading config "` + s + `" failed: ` + err.Error())
+	}
+	if err = c.decode(s, j); err != nil {
+		return c, errors.New(`parsing config "` + s + `" failed: ` + err.Error())
+	}
+	if err = c.environ(); err != nil {
+		return c, errors.New("config environment override failed: " + err.Error())
+	}
+	if err = c.check(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+func newContainer(i int, b BotConfig, d func(BotConfig) (Client, error)) (*container, error) {
+	v, err := d(b)
+	if err != nil {
+		return nil, errors.New("bot " + strconv.Itoa(i) + ": login failed: " + err.Error())
+	}
+	return &container{
+		bot:     v,
+		key:     b.Key,
+		api:     b.API,
+		recv:    b.Channel,
+		archive: b.Archive,
+		limit:   b.MaxSize,
+		scratch: b.Scratch,
+		wake:    make(chan struct{}, 1),
+		files:   b.Files,
+		users:   append(make([]int64, 0, len(b.Users)), b.Users...),
+	}, nil
+}
+
+// Reload re-reads the config file the Forwarder was created from and applies it
+// with 'ReloadConfig'. This returns an error if the Forwarder was not created
+// from a config file or the config file is not valid.
+func (f *Forwarder) Reload() error {
+	if len(f.file) == 0 {
+		return errors.New("no config file to reload")
+	}
+	f.log.Info(`Reloading config "%s"..`, f.file)
+	c, err := LoadConfig(f.file)
+	if err != nil {
+		f.log.Error("Reload failed, keeping the current config: %s!", err.Error())
+		return err
+	}
+	return f.ReloadConfig(c)
+}
+
+// ReloadConfig validates the supplied Config and queues it to be applied by
+// 'Run'. Changes to the log level and bots are applied without stopping, while
+// Database, HTTP, Webhook, import and log file changes require a restart. A
+// changed Channel of a running bot is ignored, with an error logged, and the rest
+// of the Config is applied, use 'Migrate' instead. If the Forwarder is not
+// running, the Config is applied once 'Run' is called.
+func (f *Forwarder) ReloadConfig(c Config) error {
+	err := c.check()
+	if err == nil && !f.custom {
+		err = c.checkDatabase()
+	}
+	if err == nil && len(c.Bots) == 0 {
+		err = errors.New("no telegram accounts")
+	}
+	if err != nil {
+		f.log.Error("Reload failed, keeping the current config: %s!", err.Error())
+		return err
+	}
+	select {
+	case f.reloads <- c:
+		return nil
+	default:
+		return errors.New("a reload is already pending")
+	}
+}
+
+// reload applies any changes to the log level and bots. Bots with unchanged
+// keys and endpoints keep their update loops and only have their settings
+// replaced. Bots with a new key (or API endpoint) are logged in and started,
+// while any bots no longer in the config are stopped.
+//
+// The Channel of a running bot is not changed, as the records only store the
+// Message IDs, which would then refer to unrelated Messages in the new Channel.
+func (f *Forwarder) reload(x context.Context, g *sync.WaitGroup, c Config) {
+	if c.Database != f.conf.Database || c.HTTP != f.conf.HTTP || c.Webhook != f.conf.Webhook || c.Log.File != f.conf.Log.File || c.Log.Format != f.conf.Log.Format || c.Import != f.conf.Import {
+		f.log.Warning("Database, HTTP, Webhook, import, log file or log format changes will not be applied until restart.")
+	}
+	f.log.SetLevel(logx.Level(c.Log.Level))
+	var (
+		o = f.list()
+		k = make(map[string]*container, len(o))
+		n = make([]*container, 0, len(c.Bots))
+	)
+	for _, v := range o {
+		k[v.key] = v
+	}
+	for i := range c.Bots {
+		v, ok := k[c.Bots[i].Key]
+		if ok && v.channel() != c.Bots[i].Channel {
+			f.event(v.scope(x), logx.Error, `Ignoring the Channel change to "%d", use -migrate to move the records to the new Channel!`, c.Bots[i].Channel)
+			c.Bots[i].Channel = v.channel()
+		}
+		if ok && v.api == c.Bots[i].API {
+			delete(k, v.key)
+			v.update(c.Bots[i])
+			v.authorize(x, f)
+			n = append(n, v)
+			f.event(v.scope(x), logx.Debug, "Updated settings.")
+			continue
+		}
+		z, err := newContainer(i, c.Bots[i], f.dial)
+		if err != nil {
+			f.log.Error("Could not add bot %d: %s!", i, err.Error())
+			if ok {
+				delete(k, v.key)
+				n = append(n, v)
+			}
+			continue
+		}
+		if ok {
+			delete(k, v.key)
+			f.event(v.scope(x), logx.Debug, "API endpoint changed, restarting..")
+			v.stop(f)
+		}
+		z.start(x, f, g)
+		n = append(n, z)
+		f.event(z.scope(x), logx.Info, "Started bot.")
+	}
+	for _, v := range k {
+		f.event(v.scope(x), logx.Info, "Bot removed from the config, stopping..")
+		v.stop(f)
+	}
+	f.lock.Lock()
+	f.bots, f.conf = n, c
+	f.lock.Unlock()
+	f.log.Info("Reload complete, running %d bots.", len(n))
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/PurpleSec/logx"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	sendRetries = 5
+	paceChannel = time.Second * 3
+	pacePrivate = time.Second
+)
+
+type pacer struct {
+	next map[int64]time.Time
+	lock sync.Mutex
+}
+
+func pace(k int64) time.Duration {
+	if k > 0 {
+		return pacePrivate
+	}
+	return paceChannel
+}
+func sleep(x context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+		return nil
+	case <-x.Done():
+		t.Stop()
+		return x.Err()
+	}
+}
+func (p *pacer) prune(n time.Time) {
+	p.lock.Lock()
+	for k, v := range p.next {
+		if v.Before(n) {
+			delete(p.next, k)
+		}
+	}
+	p.lock.Unlock()
+}
+func chatOf(n telegram.Chattable) int64 {
+	switch v := n.(type) {
+	case telegram.MessageConfig:
+		return v.ChatID
+	case telegram.PhotoConfig:
+		return v.ChatID
+	case telegram.VideoConfig:
+		return v.ChatID
+	case telegram.AnimationConfig:
+		return v.ChatID
+	case telegram.ForwardConfig:
+		return v.ChatID
+	case telegram.DeleteMessageConfig:
+		return v.ChatID
+	case telegram.EditMessageMediaConfig:
+		return v.ChatID
+	}
+	return 0
+}
+func (p *pacer) delay(k int64, d time.Duration) {
+	if k == 0 {
+		return
+	}
+	p.lock.Lock()
+	if p.next == nil {
+		p.next = make(map[int64]time.Time)
+	}
+	if t := time.Now().Add(d); p.next[k].Before(t) {
+		p.next[k] = t
+	}
+	p.lock.Unlock()
+}
+func (p *pacer) wait(x context.Context, k int64) error {
+	if k == 0 {
+		return nil
+	}
+	p.lock.Lock()
+	if p.next == nil {
+		p.next = make(map[int64]time.Time)
+	}
+	var (
+		n = time.Now()
+		t = p.next[k]
+	)
+	if t.Before(n) {
+		t = n
+	}
+	p.next[k] = t.Add(pace(k))
+	p.lock.Unlock()
+	return sleep(x, time.Until(t))
+}
+
+// call will send the Chattable to Telegram while respecting the per-chat pacing
+// limits and returns the API response.
+func (c *container) call(x context.Context, f *Forwarder, n telegram.Chattable) (*telegram.APIResponse, error) {
+	var r *telegram.APIResponse
+	err := c.retry(x, f, chatOf(n), func() (err error) {
+		r, err = c.bot.Request(x, n)
+		return err
+	})
+	return r, err
+}
+
+// retry runs the function while respecting the per-chat pacing limits. Requests
+// that hit a flood limit or a transport error are retried up to 'sendRetries'
+// times, using the 'retry_after' value returned by Telegram when present.
+func (c *container) retry(x context.Context, f *Forwarder, k int64, g func() error) error {
+	var err error
+	for i := 0; i < sendRetries; i++ {
+		if err = c.pace.wait(x, k); err != nil {
+			return err
+		}
+		if err = g(); err == nil {
+			return nil
+		}
+		var (
+			e *telegram.Error
+			d = time.Second << uint(i)
+		)
+		if errors.As(err, &e) {
+			if e.Code != 429 {
+				return err
+			}
+			if e.RetryAfter > 0 {
+				d = time.Duration(e.RetryAfter) * time.Second
+			}
+			c.pace.delay(k, d)
+		}
+		if i+1 >= sendRetries {
+			break
+		}
+		f.event(x, logx.Warning, "Request to chat %d failed (attempt %d/%d), retrying in %s: %s!", k, i+1, sendRetries, d, err.Error())
+		if w := sleep(x, d); w != nil {
+			return w
+		}
+	}
+	return err
+}
+func (c *container) sendMessage(x context.Context, f *Forwarder, n telegram.Chattable) (telegram.Message, error) {
+	var m telegram.Message
+	err := c.retry(x, f, chatOf(n), func() (err error) {
+		m, err = c.bot.Send(x, n)
+		return err
+	})
+	return m, err
+}
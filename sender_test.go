@@ -0,0 +1,69 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/PurpleSec/logx"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRetry(t *testing.T) {
+	var (
+		f        = &Forwarder{log: logx.NOP}
+		flood    = &telegram.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: telegram.ResponseParameters{RetryAfter: 1}}
+		rejected = &telegram.Error{Code: 400, Message: "Bad Request"}
+		reset    = errors.New("connection reset")
+	)
+	for _, v := range []struct {
+		name   string
+		errs   []error
+		cancel bool
+		calls  int
+		err    error
+	}{
+		{"success", []error{nil}, false, 1, nil},
+		{"rejected", []error{rejected, nil}, false, 1, rejected},
+		{"flood limit", []error{flood, nil}, false, 2, nil},
+		{"transport error", []error{reset, nil}, false, 2, nil},
+		{"canceled", []error{reset, nil}, true, 1, context.Canceled},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			var (
+				c    container
+				n    int
+				x, y = context.WithCancel(context.Background())
+			)
+			defer y()
+			err := c.retry(x, f, 0, func() error {
+				if n++; v.cancel {
+					y()
+				}
+				return v.errs[n-1]
+			})
+			if n != v.calls {
+				t.Fatalf("expected %d calls, got %d", v.calls, n)
+			}
+			if !errors.Is(err, v.err) {
+				t.Fatalf("expected error %v, got %v", v.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/PurpleSec/mapper"
+)
+
+// Image is an Image record in a Storage. A record with a zero Message is a
+// reservation for an Image that is being posted.
+type Image struct {
+	ID      uint64
+	Bot     int64
+	Hash    uint64
+	File    string
+	Message uint64
+}
+
+// Task is a request in the durable Queue of a Storage, which is kept until the
+// request is delivered so it can be replayed after a restart.
+type Task struct {
+	ID      uint64
+	Chat    int64
+	Message uint64
+	Action  uint8
+}
+
+// Storage is the interface used by a Forwarder to store its records. Functions
+// that take a bot ID match the records of every bot when the ID is zero.
+type Storage interface {
+	Close() error
+	Ping(x context.Context) error
+	// Get returns the record with the ID. The boolean is false if there is no
+	// record with the ID.
+	Get(x context.Context, id uint64) (Image, bool, error)
+	// List calls the function for every bound record of the bot, ordered by bot
+	// and Message. Any error returned by the function stops the List.
+	List(x context.Context, bot int64, f func(Image) error) error
+	// Sample returns up to 'n' randomly picked bound records of the bot.
+	Sample(x context.Context, bot int64, n int) ([]Image, error)
+	// FindFile returns the records of the bot with the file hash.
+	FindFile(x context.Context, bot int64, file string) ([]Image, error)
+	// FindHash returns the records of the bot with the Image hash.
+	FindHash(x context.Context, bot int64, hash uint64) ([]Image, error)
+	// FindMessage returns the records of the bot bound to the Message.
+	FindMessage(x context.Context, bot int64, message uint64) ([]Image, error)
+	// Reserve returns the ID of the record of the bot with the Image hash. If
+	// there is none, a new unbound record is added and the boolean is false.
+	Reserve(x context.Context, bot int64, hash uint64, file string) (uint64, bool, error)
+	// Bind sets the Message of the record. The boolean is false if there is no
+	// record with the ID, such as a reservation that was released.
+	Bind(x context.Context, id, message uint64) (bool, error)
+	// Release removes the record if it is an unbound reservation.
+	Release(x context.Context, id uint64) error
+	// ReleaseAll removes every unbound reservation of the bot and returns the
+	// number removed.
+	ReleaseAll(x context.Context, bot int64) (int, error)
+	// Update sets the hashes of the record.
+	Update(x context.Context, id, hash uint64, file string) error
+	// Remove removes the record.
+	Remove(x context.Context, id uint64) error
+	// Delete removes the bound records of the bot with the file hash and returns
+	// the Message they were bound to, or zero if there were none.
+	Delete(x context.Context, bot int64, file string) (uint64, error)
+	// Insert adds the Images in a single transaction, skipping any Image with a
+	// hash that already exists for the bot, and returns the number added.
+	Insert(x context.Context, e []Image) (int, error)
+	// Enqueue adds the Task to the Queue of the bot and returns its ID.
+	Enqueue(x context.Context, bot int64, t Task) (uint64, error)
+	// Dequeue removes the Task from the Queue.
+	Dequeue(x context.Context, id uint64) error
+	// Pending returns the Tasks in the Queue of the bot, in the order added.
+	Pending(x context.Context, bot int64) ([]Task, error)
+	// Users returns the authorized user changes of the bot, with true for added
+	// users and false for removed users.
+	Users(x context.Context, bot int64) (map[int64]bool, error)
+	// SetUser saves an authorized user change of the bot, replacing any earlier
+	// change of the user.
+	SetUser(x context.Context, bot, user int64, allowed bool) error
+}
+type mysql struct {
+	m *mapper.Map
+}
+
+func (m mysql) Close() error {
+	return m.m.Close()
+}
+func (m mysql) Ping(x context.Context) error {
+	return m.m.Database.PingContext(x)
+}
+func (m mysql) images(x context.Context, n string, v ...any) ([]Image, error) {
+	r, err := m.m.QueryContext(x, n, v...)
+	if err != nil {
+		return nil, err
+	}
+	var e []Image
+	for r.Next() {
+		var i Image
+		if err = r.Scan(&i.ID, &i.Hash, &i.File, &i.Bot, &i.Message); err != nil {
+			break
+		}
+		e = append(e, i)
+	}
+	if err == nil {
+		err = r.Err()
+	}
+	r.Close()
+	return e, err
+}
+func (m mysql) Get(x context.Context, id uint64) (Image, bool, error) {
+	e, err := m.images(x, "image_get", id)
+	if err != nil || len(e) == 0 {
+		return Image{}, false, err
+	}
+	return e[0], true, nil
+}
+func (m mysql) List(x context.Context, bot int64, f func(Image) error) error {
+	r, err := m.m.QueryContext(x, "image_list", bot, bot)
+	if err != nil {
+		return err
+	}
+	for r.Next() {
+		var i Image
+		if err = r.Scan(&i.ID, &i.Hash, &i.File, &i.Bot, &i.Message); err != nil {
+			break
+		}
+		if err = f(i); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = r.Err()
+	}
+	r.Close()
+	return err
+}
+func (m mysql) Sample(x context.Context, bot int64, n int) ([]Image, error) {
+	return m.images(x, "image_sample", bot, bot, n)
+}
+func (m mysql) FindFile(x context.Context, bot int64, file string) ([]Image, error) {
+	return m.images(x, "image_file", bot, bot, file)
+}
+func (m mysql) FindHash(x context.Context, bot int64, hash uint64) ([]Image, error) {
+	return m.images(x, "image_hash", bot, bot, hash)
+}
+func (m mysql) FindMessage(x context.Context, bot int64, message uint64) ([]Image, error) {
+	return m.images(x, "image_message", bot, bot, message)
+}
+func (m mysql) Reserve(x context.Context, bot int64, hash uint64, file string) (uint64, bool, error) {
+	// The unique key on the bot and Image hash makes this a single atomic
+	// statement. An existing row sets the insert ID to its own ID and is not
+	// changed, so it returns zero affected rows.
+	r, err := m.m.ExecContext(x, "image_reserve", hash, file, bot)
+	if err != nil {
+		return 0, false, err
+	}
+	i, err := r.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := r.RowsAffected()
+	return uint64(i), n != 1, err
+}
+func (m mysql) Bind(x context.Context, id, message uint64) (bool, error) {
+	r, err := m.m.ExecContext(x, "image_bind", message, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	return n > 0, err
+}
+func (m mysql) Release(x context.Context, id uint64) error {
+	_, err := m.m.ExecContext(x, "image_release", id)
+	return err
+}
+func (m mysql) ReleaseAll(x context.Context, bot int64) (int, error) {
+	r, err := m.m.ExecContext(x, "image_unbound", bot)
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.RowsAffected()
+	return int(n), err
+}
+func (m mysql) Update(x context.Context, id, hash uint64, file string) error {
+	_, err := m.m.ExecContext(x, "image_update", hash, file, id)
+	return err
+}
+func (m mysql) Remove(x context.Context, id uint64) error {
+	_, err := m.m.ExecContext(x, "image_remove", id)
+	return err
+}
+func (m mysql) Delete(x context.Context, bot int64, file string) (uint64, error) {
+	z, err := m.m.Database.BeginTx(x, nil)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		s, _ = m.m.Get("image_deleted")
+		n    uint64
+	)
+	switch err = z.StmtContext(x, s).QueryRowContext(x, file, bot).Scan(&n); {
+	case err == sql.ErrNoRows:
+		return 0, z.Commit()
+	case err != nil:
+		z.Rollback()
+		return 0, err
+	}
+	s, _ = m.m.Get("image_delete")
+	if _, err = z.StmtContext(x, s).ExecContext(x, n, file, bot); err != nil {
+		z.Rollback()
+		return 0, err
+	}
+	return n, z.Commit()
+}
+func (m mysql) Insert(x context.Context, e []Image) (int, error) {
+	if len(e) == 0 {
+		return 0, nil
+	}
+	var (
+		q strings.Builder
+		v = make([]any, 0, len(e)*4)
+	)
+	q.WriteString("INSERT INTO Images(ImageHash, ImageFileHash, ImageBotID, ImageMessageID) SELECT v.h, v.f, v.b, v.m FROM (")
+	for i := range e {
+		if i > 0 {
+			q.WriteString(" UNION ALL ")
+		}
+		// The cast keeps the Image hashes with the top bit set from being read
+		// as a signed value in the derived table.
+		q.WriteString("SELECT CAST(? AS UNSIGNED) AS h, ? AS f, ? AS b, ? AS m")
+		v = append(v, e[i].Hash, e[i].File, e[i].Bot, e[i].Message)
+	}
+	// Records repeated in the batch are skipped by the unique key, without
+	// changing the first one.
+	q.WriteString(") AS v WHERE NOT EXISTS (SELECT 1 FROM Images WHERE ImageHash = v.h AND ImageBotID = v.b) ON DUPLICATE KEY UPDATE ImageID = Images.ImageID")
+	z, err := m.m.Database.BeginTx(x, nil)
+	if err != nil {
+		return 0, err
+	}
+	r, err := z.ExecContext(x, q.String(), v...)
+	if err != nil {
+		z.Rollback()
+		return 0, err
+	}
+	if err = z.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := r.RowsAffected()
+	return int(n), nil
+}
+func (m mysql) Enqueue(x context.Context, bot int64, t Task) (uint64, error) {
+	r, err := m.m.ExecContext(x, "queue_add", bot, t.Chat, t.Message, t.Action)
+	if err != nil {
+		return 0, err
+	}
+	i, err := r.LastInsertId()
+	return uint64(i), err
+}
+func (m mysql) Dequeue(x context.Context, id uint64) error {
+	_, err := m.m.ExecContext(x, "queue_remove", id)
+	return err
+}
+func (m mysql) Pending(x context.Context, bot int64) ([]Task, error) {
+	r, err := m.m.QueryContext(x, "queue_list", bot)
+	if err != nil {
+		return nil, err
+	}
+	var e []Task
+	for r.Next() {
+		var t Task
+		if err = r.Scan(&t.ID, &t.Chat, &t.Message, &t.Action); err != nil {
+			break
+		}
+		e = append(e, t)
+	}
+	if err == nil {
+		err = r.Err()
+	}
+	r.Close()
+	return e, err
+}
+func (m mysql) Users(x context.Context, bot int64) (map[int64]bool, error) {
+	r, err := m.m.QueryContext(x, "user_list", bot)
+	if err != nil {
+		return nil, err
+	}
+	u := make(map[int64]bool)
+	for r.Next() {
+		var (
+			i int64
+			v bool
+		)
+		if err = r.Scan(&i, &v); err != nil {
+			break
+		}
+		u[i] = v
+	}
+	if err == nil {
+		err = r.Err()
+	}
+	r.Close()
+	return u, err
+}
+func (m mysql) SetUser(x context.Context, bot, user int64, allowed bool) error {
+	_, err := m.m.ExecContext(x, "user_set", bot, user, allowed)
+	return err
+}
+
+// openMySQL connects to the MySQL database and creates the schema. If 'empty' is
+// true, all existing data is removed first.
+func openMySQL(c DatabaseConfig, empty bool) (Storage, error) {
+	d, err := sql.Open(
+		"mysql",
+		c.Username+":"+c.Password+"@"+c.Server+"/"+c.Name+"?multiStatements=true&interpolateParams=true",
+	)
+	if err != nil {
+		return nil, errors.New(`database connection "` + c.Server + `" failed: ` + err.Error())
+	}
+	if err = d.Ping(); err != nil {
+		d.Close()
+		return nil, errors.New(`database connection "` + c.Server + `" failed: ` + err.Error())
+	}
+	m := mapper.New(d)
+	if d.SetConnMaxLifetime(c.Timeout); empty {
+		if err = m.Batch(cleanStatements); err != nil {
+			m.Close()
+			return nil, errors.New("clean up failed: " + err.Error())
+		}
+	}
+	if err = m.Batch(setupStatements); err != nil {
+		m.Close()
+		return nil, errors.New("database schema setup failed: " + err.Error())
+	}
+	if err = upgradeMySQL(d); err != nil {
+		m.Close()
+		return nil, errors.New("database schema upgrade failed: " + err.Error())
+	}
+	if err = m.Extend(queryStatements); err != nil {
+		m.Close()
+		return nil, errors.New("database schema extend failed: " + err.Error())
+	}
+	return mysql{m}, nil
+}
+
+// upgradeMySQL adds any keys in 'upgradeStatements' that are missing from the
+// tables. Adding the unique Image hash key fails if a bot already has more than
+// one record with the same Image hash, these must be removed first.
+func upgradeMySQL(d *sql.DB) error {
+	for _, v := range upgradeStatements {
+		var n int
+		err := d.QueryRow(`SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND INDEX_NAME = ?`, v[0]).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err = d.Exec(v[1]); err != nil {
+			return errors.New(`cannot add key "` + v[0] + `": ` + err.Error())
+		}
+	}
+	return nil
+}
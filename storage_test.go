@@ -0,0 +1,424 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	driver "github.com/go-sql-driver/mysql"
+)
+
+// testMySQL is the environment variable with the DSN of a MySQL database used to
+// run the Storage tests against the MySQL Storage. ALL DATA in the database is
+// removed by the tests.
+const testMySQL = "FORWARDER_TEST_MYSQL"
+
+// memory is an in-memory Storage with the same behavior as the MySQL Storage,
+// checked by running 'testStorage' against both.
+type memory struct {
+	lock   sync.Mutex
+	last   uint64
+	users  map[[2]int64]bool
+	queue  []memQueue
+	images []Image
+}
+type memQueue struct {
+	Task
+	bot int64
+}
+
+func (*memory) Close() error {
+	return nil
+}
+func (m *memory) count() int {
+	m.lock.Lock()
+	n := len(m.images)
+	m.lock.Unlock()
+	return n
+}
+func (*memory) Ping(_ context.Context) error {
+	return nil
+}
+func (m *memory) find(f func(Image) bool) int {
+	for i := range m.images {
+		if f(m.images[i]) {
+			return i
+		}
+	}
+	return -1
+}
+func (m *memory) filter(f func(Image) bool) []Image {
+	m.lock.Lock()
+	var e []Image
+	for _, i := range m.images {
+		if f(i) {
+			e = append(e, i)
+		}
+	}
+	m.lock.Unlock()
+	return e
+}
+func (m *memory) remove(f func(Image) bool) int {
+	var (
+		n int
+		r = m.images[:0]
+	)
+	for _, v := range m.images {
+		if f(v) {
+			n++
+			continue
+		}
+		r = append(r, v)
+	}
+	m.images = r
+	return n
+}
+func (m *memory) Get(_ context.Context, id uint64) (Image, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if i := m.find(func(i Image) bool { return i.ID == id }); i >= 0 {
+		return m.images[i], true, nil
+	}
+	return Image{}, false, nil
+}
+func (m *memory) List(_ context.Context, bot int64, f func(Image) error) error {
+	e := m.filter(func(i Image) bool { return (bot == 0 || i.Bot == bot) && i.Message > 0 })
+	sort.Slice(e, func(i, j int) bool {
+		if e[i].Bot != e[j].Bot {
+			return e[i].Bot < e[j].Bot
+		}
+		return e[i].Message < e[j].Message
+	})
+	for i := range e {
+		if err := f(e[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (m *memory) Sample(_ context.Context, bot int64, n int) ([]Image, error) {
+	e := m.filter(func(i Image) bool { return (bot == 0 || i.Bot == bot) && i.Message > 0 })
+	rand.Shuffle(len(e), func(i, j int) { e[i], e[j] = e[j], e[i] })
+	if len(e) > n {
+		e = e[:n]
+	}
+	return e, nil
+}
+func (m *memory) FindFile(_ context.Context, bot int64, file string) ([]Image, error) {
+	return m.filter(func(i Image) bool { return (bot == 0 || i.Bot == bot) && i.File == file }), nil
+}
+func (m *memory) FindHash(_ context.Context, bot int64, hash uint64) ([]Image, error) {
+	return m.filter(func(i Image) bool { return (bot == 0 || i.Bot == bot) && i.Hash == hash }), nil
+}
+func (m *memory) FindMessage(_ context.Context, bot int64, message uint64) ([]Image, error) {
+	return m.filter(func(i Image) bool { return (bot == 0 || i.Bot == bot) && i.Message == message }), nil
+}
+func (m *memory) Reserve(_ context.Context, bot int64, hash uint64, file string) (uint64, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if i := m.find(func(i Image) bool { return i.Hash == hash && i.Bot == bot }); i >= 0 {
+		return m.images[i].ID, true, nil
+	}
+	m.last++
+	m.images = append(m.images, Image{ID: m.last, Bot: bot, Hash: hash, File: file})
+	return m.last, false, nil
+}
+func (m *memory) Bind(_ context.Context, id, message uint64) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if i := m.find(func(i Image) bool { return i.ID == id }); i >= 0 {
+		m.images[i].Message = message
+		return true, nil
+	}
+	return false, nil
+}
+func (m *memory) Release(_ context.Context, id uint64) error {
+	m.lock.Lock()
+	m.remove(func(i Image) bool { return i.ID == id && i.Message == 0 })
+	m.lock.Unlock()
+	return nil
+}
+func (m *memory) ReleaseAll(_ context.Context, bot int64) (int, error) {
+	m.lock.Lock()
+	n := m.remove(func(i Image) bool { return i.Bot == bot && i.Message == 0 })
+	m.lock.Unlock()
+	return n, nil
+}
+func (m *memory) Update(_ context.Context, id, hash uint64, file string) error {
+	m.lock.Lock()
+	if i := m.find(func(i Image) bool { return i.ID == id }); i >= 0 {
+		m.images[i].Hash, m.images[i].File = hash, file
+	}
+	m.lock.Unlock()
+	return nil
+}
+func (m *memory) Remove(_ context.Context, id uint64) error {
+	m.lock.Lock()
+	m.remove(func(i Image) bool { return i.ID == id })
+	m.lock.Unlock()
+	return nil
+}
+func (m *memory) Delete(_ context.Context, bot int64, file string) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	i := m.find(func(i Image) bool { return i.File == file && i.Bot == bot && i.Message > 0 })
+	if i < 0 {
+		return 0, nil
+	}
+	k := m.images[i].Message
+	m.remove(func(i Image) bool { return i.Message == k && i.File == file && i.Bot == bot })
+	return k, nil
+}
+func (m *memory) Insert(_ context.Context, e []Image) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var n int
+	for _, v := range e {
+		if m.find(func(i Image) bool { return i.Hash == v.Hash && i.Bot == v.Bot }) >= 0 {
+			continue
+		}
+		m.last++
+		v.ID = m.last
+		m.images = append(m.images, v)
+		n++
+	}
+	return n, nil
+}
+func (m *memory) Enqueue(_ context.Context, bot int64, t Task) (uint64, error) {
+	m.lock.Lock()
+	m.last++
+	t.ID = m.last
+	m.queue = append(m.queue, memQueue{Task: t, bot: bot})
+	m.lock.Unlock()
+	return t.ID, nil
+}
+func (m *memory) Dequeue(_ context.Context, id uint64) error {
+	m.lock.Lock()
+	for i := range m.queue {
+		if m.queue[i].ID == id {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+	m.lock.Unlock()
+	return nil
+}
+func (m *memory) Pending(_ context.Context, bot int64) ([]Task, error) {
+	m.lock.Lock()
+	var e []Task
+	for _, q := range m.queue {
+		if q.bot == bot {
+			e = append(e, q.Task)
+		}
+	}
+	m.lock.Unlock()
+	return e, nil
+}
+func (m *memory) Users(_ context.Context, bot int64) (map[int64]bool, error) {
+	m.lock.Lock()
+	u := make(map[int64]bool)
+	for k, v := range m.users {
+		if k[0] == bot {
+			u[k[1]] = v
+		}
+	}
+	m.lock.Unlock()
+	return u, nil
+}
+func (m *memory) SetUser(_ context.Context, bot, user int64, allowed bool) error {
+	m.lock.Lock()
+	if m.users == nil {
+		m.users = make(map[[2]int64]bool)
+	}
+	m.users[[2]int64{bot, user}] = allowed
+	m.lock.Unlock()
+	return nil
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, new(memory))
+}
+func TestMySQLStorage(t *testing.T) {
+	v := os.Getenv(testMySQL)
+	if len(v) == 0 {
+		t.Skip(testMySQL + " is not set")
+	}
+	c, err := driver.ParseDSN(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := openMySQL(DatabaseConfig{Name: c.DBName, Server: c.Net + "(" + c.Addr + ")", Username: c.User, Password: c.Passwd}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStorage(t, s)
+}
+func messages(t *testing.T, s Storage, b int64) []uint64 {
+	t.Helper()
+	var r []uint64
+	if err := s.List(context.Background(), b, func(i Image) error {
+		r = append(r, i.Message)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+func equal(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// testStorage checks the behavior of an empty Storage that the Forwarder relies
+// on.
+func testStorage(t *testing.T, s Storage) {
+	x := context.Background()
+	if err := s.Ping(x); err != nil {
+		t.Fatal(err)
+	}
+	// The Image hash uses all 64 bits, so the top bit must be kept.
+	const h = 1<<63 | 0xF00D
+	n, ok, err := s.Reserve(x, 1, h, "file1")
+	if err != nil || ok || n == 0 {
+		t.Fatalf("first reserve returned %d, %t, %v", n, ok, err)
+	}
+	if v, ok, err := s.Reserve(x, 1, h, "file1"); err != nil || !ok || v != n {
+		t.Fatalf("second reserve returned %d, %t, %v, expected %d", v, ok, err, n)
+	}
+	if v, ok, err := s.Reserve(x, 2, h, "file1"); err != nil || ok || v == n {
+		t.Fatalf("reserve for another bot returned %d, %t, %v", v, ok, err)
+	}
+	if e := messages(t, s, 0); len(e) != 0 {
+		t.Fatalf("unbound reservations were listed: %v", e)
+	}
+	if ok, err = s.Bind(x, n, 30); err != nil || !ok {
+		t.Fatalf("bind returned %t, %v", ok, err)
+	}
+	if ok, err = s.Bind(x, n+1000, 31); err != nil || ok {
+		t.Fatalf("bind of a missing record returned %t, %v", ok, err)
+	}
+	if i, ok, err := s.Get(x, n); err != nil || !ok || i.Bot != 1 || i.Hash != h || i.File != "file1" || i.Message != 30 {
+		t.Fatalf("get returned %+v, %t, %v", i, ok, err)
+	}
+	if _, ok, err := s.Get(x, n+1000); err != nil || ok {
+		t.Fatalf("get of a missing record returned %t, %v", ok, err)
+	}
+	if err = s.Release(x, n); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Get(x, n); !ok {
+		t.Fatal("release removed a bound record")
+	}
+	if c, err := s.ReleaseAll(x, 2); err != nil || c != 1 {
+		t.Fatalf("release all returned %d, %v", c, err)
+	}
+	c, err := s.Insert(x, []Image{
+		{Bot: 1, Hash: 3, File: "file3", Message: 20},
+		{Bot: 1, Hash: h, File: "file1", Message: 99},
+		{Bot: 2, Hash: 4, File: "file4", Message: 5},
+		{Bot: 1, Hash: 5, File: "file5", Message: 10},
+		{Bot: 2, Hash: 4, File: "file6", Message: 6},
+	})
+	if err != nil || c != 3 {
+		t.Fatalf("insert returned %d, %v", c, err)
+	}
+	if e := messages(t, s, 1); !equal(e, []uint64{10, 20, 30}) {
+		t.Fatalf("list of bot 1 returned %v", e)
+	}
+	if e := messages(t, s, 0); !equal(e, []uint64{10, 20, 30, 5}) {
+		t.Fatalf("list of all bots returned %v", e)
+	}
+	if e, err := s.FindFile(x, 0, "file1"); err != nil || len(e) != 1 || e[0].ID != n {
+		t.Fatalf("find file returned %+v, %v", e, err)
+	}
+	if e, err := s.FindHash(x, 2, 4); err != nil || len(e) != 1 || e[0].Message != 5 {
+		t.Fatalf("find hash returned %+v, %v", e, err)
+	}
+	if e, err := s.FindMessage(x, 1, 5); err != nil || len(e) != 0 {
+		t.Fatalf("find message of another bot returned %+v, %v", e, err)
+	}
+	if e, err := s.Sample(x, 1, 2); err != nil || len(e) != 2 || e[0].Bot != 1 || e[1].Bot != 1 {
+		t.Fatalf("sample returned %+v, %v", e, err)
+	}
+	if err = s.Update(x, n, 7, "file7"); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := s.FindHash(x, 1, 7); err != nil || len(e) != 1 || e[0].File != "file7" {
+		t.Fatalf("find of the updated record returned %+v, %v", e, err)
+	}
+	if m, err := s.Delete(x, 1, "file7"); err != nil || m != 30 {
+		t.Fatalf("delete returned %d, %v", m, err)
+	}
+	if m, err := s.Delete(x, 1, "file7"); err != nil || m != 0 {
+		t.Fatalf("second delete returned %d, %v", m, err)
+	}
+	e, _ := s.FindMessage(x, 1, 20)
+	if len(e) != 1 {
+		t.Fatalf("find message returned %+v", e)
+	}
+	if err = s.Remove(x, e[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if e := messages(t, s, 0); !equal(e, []uint64{10, 5}) {
+		t.Fatalf("list after the removes returned %v", e)
+	}
+	a, err := s.Enqueue(x, 1, Task{Chat: -100, Message: 10, Action: actionDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Enqueue(x, 2, Task{Chat: -200, Message: 5, Action: actionDelete}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Enqueue(x, 1, Task{Chat: -100, Message: 20, Action: actionDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := s.Pending(x, 1)
+	if err != nil || len(q) != 2 || q[0].ID != a || q[1].ID != b || q[1].Chat != -100 || q[1].Message != 20 || q[1].Action != actionDelete {
+		t.Fatalf("pending returned %+v, %v", q, err)
+	}
+	if err = s.Dequeue(x, a); err != nil {
+		t.Fatal(err)
+	}
+	if q, err = s.Pending(x, 1); err != nil || len(q) != 1 || q[0].ID != b {
+		t.Fatalf("pending after the dequeue returned %+v, %v", q, err)
+	}
+	for _, v := range []struct {
+		b, u int64
+		a    bool
+	}{{1, 10, true}, {1, 11, true}, {1, 10, false}, {2, 12, true}} {
+		if err = s.SetUser(x, v.b, v.u, v.a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u, err := s.Users(x, 1); err != nil || len(u) != 2 || u[10] || !u[11] {
+		t.Fatalf("users returned %v, %v", u, err)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/PurpleSec/logx"
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -37,27 +38,72 @@ type imported struct {
 	Image string `json:"image"`
 }
 type container struct {
-	ch    chan telegram.Chattable
-	key   string
-	bot   *telegram.BotAPI
-	recv  int64
-	users []int64
+	ch      chan telegram.Chattable
+	cancel  context.CancelFunc
+	key     string
+	bot     Client
+	api     string
+	pace    pacer
+	archive string
+	limit   int64
+	lock    sync.RWMutex
+	path    string
+	health  health
+	files   string
+	recv    int64
+	users   []int64
+	scratch int64
+	secret  string
+	updates chan telegram.Update
+	done    <-chan struct{}
+	wake    chan struct{}
 }
 type maps[T comparable] struct {
 	v    map[T]caption
 	lock sync.Mutex
 }
 
-func (c *container) stop() {
-	c.bot.StopReceivingUpdates()
-	close(c.ch)
-	c.bot, c.ch = nil, nil
+func (c *container) stop(f *Forwarder) {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if len(c.hookPath()) > 0 {
+		if err := f.hook.remove(c); err != nil {
+			f.event(c.scope(context.Background()), logx.Warning, "Could not remove the Webhook: %s!", err.Error())
+		}
+	}
+}
+func (c *container) pending() (int, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.ch == nil {
+		return 0, false
+	}
+	return len(c.ch), true
+}
+func (c *container) hookPath() string {
+	c.lock.RLock()
+	p := c.path
+	c.lock.RUnlock()
+	return p
+}
+func (c *container) channel() int64 {
+	c.lock.RLock()
+	r := c.recv
+	c.lock.RUnlock()
+	return r
 }
 func (m *maps[T]) set(v T, s string) {
 	m.lock.Lock()
 	m.v[v] = caption{Tag: s, Time: time.Now().Add(captionTimeout)}
 	m.lock.Unlock()
 }
+func (m *maps[T]) len() int {
+	m.lock.Lock()
+	n := len(m.v)
+	m.lock.Unlock()
+	return n
+}
 func (m *maps[int64]) clear(v int64) {
 	m.lock.Lock()
 	delete(m.v, v)
@@ -87,6 +133,9 @@ func (f *Forwarder) tick(x context.Context) {
 			f.log.Debug("Running Captions cleanup..")
 			f.caps.prune(n)
 			f.groups.prune(n)
+			for _, c := range f.list() {
+				c.pace.prune(n)
+			}
 			f.log.Debug("Captions cleanup done!")
 		}
 	}
@@ -103,8 +152,29 @@ func (m *maps[T]) get(v T, del bool) (string, bool) {
 	return r.Tag, ok
 }
 func (c *container) start(x context.Context, f *Forwarder, g *sync.WaitGroup) {
-	r := c.bot.GetUpdatesChan(telegram.UpdateConfig{})
-	c.ch = make(chan telegram.Chattable, 128)
-	go c.send(x, f, g, c.ch)
-	go c.receive(x, f, g, c.ch, r)
+	x, c.cancel = context.WithCancel(c.scope(x))
+	var r telegram.UpdatesChannel
+	if f.hook != nil {
+		if err := f.hook.register(x, c); err != nil {
+			f.event(x, logx.Warning, "Webhook registration failed, falling back to polling: %s!", err.Error())
+			c.bot.Request(x, telegram.DeleteWebhookConfig{})
+		} else {
+			f.event(x, logx.Debug, "Receiving updates via Webhook.")
+			r = c.updates
+		}
+	}
+	if r == nil {
+		v := make(chan telegram.Update, updateBuffer)
+		go c.poll(x, f, v)
+		r = v
+	}
+	c.reconcile(x, f)
+	c.authorize(x, f)
+	o := make(chan telegram.Chattable, 128)
+	c.lock.Lock()
+	c.ch = o
+	c.lock.Unlock()
+	g.Add(2)
+	go c.send(x, f, g, o)
+	go c.receive(x, f, g, o, r)
 }
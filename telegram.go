@@ -18,11 +18,13 @@ package forwarder
 
 import (
 	"context"
-	"database/sql"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/PurpleSec/logx"
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -31,17 +33,30 @@ const (
 	addAlreadyExists       = iota
 	addIsNotImage
 	addSuccess
+	addRejected
 )
 
-var emptyJpeg = []byte{
-	0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 0x4A, 0x46, 0x49, 0x46, 0x00, 0x01, 0x01, 0x00, 0x00, 0x60, 0x00, 0x60, 0x00, 0x00, 0xFF, 0xDB, 0x00, 0x43, 0x00, 0x03, 0x02, 0x02, 0x02, 0x02, 0x02, 0x03, 0x02, 0x02, 0x02, 0x03, 0x03, 0x03, 0x03, 0x04,
-	0x06, 0x04, 0x04, 0x04, 0x04, 0x04, 0x08, 0x06, 0x06, 0x05, 0x06, 0x09, 0x08, 0x0A, 0x0A, 0x09, 0x08, 0x09, 0x09, 0x0A, 0x0C, 0x0F, 0x0C, 0x0A, 0x0B, 0x0E, 0x0B, 0x09, 0x09, 0x0D, 0x11, 0x0D, 0x0E, 0x0F, 0x10, 0x10, 0x11, 0x10, 0x0A, 0x0C,
-	0x12, 0x13, 0x12, 0x10, 0x13, 0x0F, 0x10, 0x10, 0x10, 0xFF, 0xC0, 0x00, 0x0B, 0x08, 0x00, 0x01, 0x00, 0x01, 0x01, 0x01, 0x11, 0x00, 0xFF, 0xC4, 0x00, 0x14, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	0x00, 0x00, 0x00, 0x09, 0xFF, 0xC4, 0x00, 0x14, 0x10, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xDA, 0x00, 0x08, 0x01, 0x01, 0x00, 0x00, 0x3F, 0x00, 0x54, 0xDF, 0xFF, 0xD9,
-}
+const (
+	pollRetry   = time.Second * 3
+	pollTimeout = 30
+)
 
 type photos []telegram.PhotoSize
 
+func outcome(v uint8) string {
+	switch v {
+	case addSuccess:
+		return "success"
+	case addRejected:
+		return "rejected"
+	case addIsNotImage:
+		return "not_image"
+	case addAlreadyExists:
+		return "duplicate"
+	}
+	return "failed"
+}
+
 func (p photos) Len() int {
 	return len(p)
 }
@@ -51,7 +66,12 @@ func (p photos) Swap(i, j int) {
 func (p photos) Less(i, j int) bool {
 	return p[i].FileSize > p[j].FileSize
 }
+func (c *container) label(v ...string) string {
+	return labels(append([]string{"bot", strconv.FormatInt(c.bot.Self().ID, 10)}, v...)...)
+}
 func (c *container) isAuthorized(u int64) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	for i := range c.users {
 		if u == c.users[i] {
 			return true
@@ -107,152 +127,246 @@ func getTarget(m *telegram.Message) (string, string) {
 	}
 	return "", ""
 }
+
+// submission returns a new Submission for the media with the user from the
+// context entry, if any.
+func (c *container) submission(x context.Context, v, m, d string) *Submission {
+	s := &Submission{Bot: c.bot.Self().ID, FileID: v, Mime: m, Caption: d}
+	if e := entryOf(x); e != nil {
+		s.User = e.User
+	}
+	return s
+}
 func (c *container) add(x context.Context, f *Forwarder, v, m, d string, o chan<- telegram.Chattable) uint8 {
-	f.log.Trace(`[bot %d]: Processing ID "%s" (mime: %s) for addition..`, c.bot.Self.ID, v, m)
-	i, err := loadImage(x, c.bot, v, m)
+	f.event(x, logx.Trace, `Processing ID "%s" (mime: %s) for addition..`, v, m)
+	s := c.submission(x, v, m, d)
+	if err := f.hooks.call(x, s, Hook.BeforeHash); err != nil {
+		f.event(x, logx.Info, `Submission "%s" was rejected before hashing: %s!`, v, err.Error())
+		return addRejected
+	}
+	i, err := loadImage(x, f, c, v, m)
 	if err == errNotImage {
+		if err = f.hooks.call(x, s, Hook.BeforePost); err != nil {
+			f.event(x, logx.Info, `Submission "%s" was rejected before posting: %s!`, v, err.Error())
+			return addRejected
+		}
+		d = s.Caption
 		switch {
 		case strings.HasSuffix(m, "/gif"):
-			o <- telegram.AnimationConfig{
+			o <- entryOf(x).tag(telegram.AnimationConfig{
 				Caption:  d,
-				BaseFile: telegram.BaseFile{File: i, BaseChat: telegram.BaseChat{ChatID: c.recv}},
-			}
+				BaseFile: telegram.BaseFile{File: i, BaseChat: telegram.BaseChat{ChatID: c.channel()}},
+			})
 		case strings.HasPrefix(m, "video/"):
-			o <- telegram.VideoConfig{
+			o <- entryOf(x).tag(telegram.VideoConfig{
 				Caption:  d,
-				BaseFile: telegram.BaseFile{File: i, BaseChat: telegram.BaseChat{ChatID: c.recv}},
-			}
+				BaseFile: telegram.BaseFile{File: i, BaseChat: telegram.BaseChat{ChatID: c.channel()}},
+			})
 		default:
-			f.log.Error(`[bot %d]: Received an invalid File "%s" (mime: %s), not forwarding it!`, c.bot.Self.ID, v, m)
+			f.event(x, logx.Error, `Received an invalid File "%s" (mime: %s), not forwarding it!`, v, m)
 			return addFailed
 		}
 		return addIsNotImage
 	}
 	if err != nil {
-		f.log.Error(`[bot %d]: Received an error processing Image "%s" (mime: %s): %s!`, c.bot.Self.ID, v, m, err.Error())
+		f.event(x, logx.Error, `Received an error processing Image "%s" (mime: %s): %s!`, v, m, err.Error())
 		return addFailed
 	}
-	f.log.Debug("[bot %d]: Processing complete: %s", c.bot.Self.ID, i)
+	f.event(x, logx.Debug, "Processing complete: %s", i)
 	if len(d) > 0 && strings.IndexByte(d, 0x23) >= 0 {
 		strings.Split(d, "#")
 	}
-	k, err := c.bot.Send(
-		telegram.PhotoConfig{
-			BaseFile: telegram.BaseFile{
-				File:     telegram.FileBytes{Name: i.Sum, Bytes: emptyJpeg},
-				BaseChat: telegram.BaseChat{ChatID: c.recv, DisableNotification: true},
-			},
-		})
-	if err != nil {
-		f.log.Error("[bot %d]: Received an error adding the Placeholder Image: %s!", c.bot.Self.ID, err.Error())
-		return addFailed
-	}
-	p := k.MessageID
-	f.log.Trace(`[bot %d]: Created a Placeholder Image "%d"!`, c.bot.Self.ID, p)
-	var (
-		e uint64
-		r *sql.Rows
-	)
-	if r, err = f.sql.QueryContext(x, "add", i.Average, i.Sum, c.bot.Self.ID, p); err != nil {
-		f.log.Error(`[bot %d]: Received an error querying the database for "0x%X": %s!`, c.bot.Self.ID, i.Average, err.Error())
-		o <- telegram.NewDeleteMessage(c.recv, p)
-		return addFailed
-	}
-	for r.Next() {
-		if err = r.Scan(&e); err != nil {
-			break
-		}
+	s.Hash, s.File = i.Average, i.Sum
+	if err = f.hooks.call(x, s, Hook.AfterHash); err != nil {
+		f.event(x, logx.Info, `Submission %s was rejected after hashing: %s!`, i, err.Error())
+		return addRejected
 	}
-	switch r.Close(); {
+	f.ingest.RLock()
+	n, e, err := f.sql.Reserve(x, c.bot.Self().ID, i.Average, i.Sum)
+	switch f.ingest.RUnlock(); {
 	case err != nil:
-		f.log.Error("[bot %d]: Received an error scanning the query results: %s!", c.bot.Self.ID, err.Error())
-		o <- telegram.NewDeleteMessage(c.recv, p)
+		f.event(x, logx.Error, `Received an error querying the database for "0x%X": %s!`, i.Average, err.Error())
 		return addFailed
-	case e != 0:
-		f.log.Trace("[bot %d]: Query verified %s is already added!", c.bot.Self.ID, i)
-		o <- telegram.NewDeleteMessage(c.recv, p)
+	case e:
+		f.event(x, logx.Trace, "Query verified %s is already added!", i)
+		if err = f.hooks.call(x, s, Hook.OnDuplicate); err != nil {
+			f.event(x, logx.Info, `Duplicate submission %s was rejected: %s!`, i, err.Error())
+			return addRejected
+		}
 		return addAlreadyExists
+	case n == 0:
+		f.event(x, logx.Error, "Received an empty reservation for %s!", i)
+		return addFailed
+	}
+	if err = f.hooks.call(x, s, Hook.BeforePost); err != nil {
+		f.event(x, logx.Info, `Submission %s was rejected before posting: %s!`, i, err.Error())
+		c.release(x, f, n)
+		return addRejected
 	}
-	f.log.Debug(`[bot %d]: Updating Message "%d" with %s to the receiving Channel "%d"..`, c.bot.Self.ID, p, i, c.recv)
-	_, err = c.bot.Send(telegram.EditMessageMediaConfig{
-		Media: telegram.InputMediaPhoto{
-			BaseInputMedia: telegram.BaseInputMedia{
-				Type:            "photo",
-				Media:           telegram.FileID(i.FileID),
-				Caption:         d,
-				ParseMode:       "markdown",
-				CaptionEntities: splitTags(d),
-			}},
-		BaseEdit: telegram.BaseEdit{ChatID: c.recv, MessageID: p},
+	d = s.Caption
+	f.event(x, logx.Debug, `Reserved "%d", posting %s to the receiving Channel "%d"..`, n, i, c.channel())
+	k, err := c.sendMessage(x, f, telegram.PhotoConfig{
+		Caption:         d,
+		ParseMode:       "markdown",
+		CaptionEntities: splitTags(d),
+		BaseFile: telegram.BaseFile{
+			File:     telegram.FileID(i.FileID),
+			BaseChat: telegram.BaseChat{ChatID: c.channel()},
+		},
 	})
 	if err != nil {
-		f.log.Error(`[bot %d]: Received an error updating the Placeholder "%d": %s!`, c.bot.Self.ID, p, err.Error())
-		o <- telegram.NewDeleteMessage(c.recv, p)
+		f.event(x, logx.Error, `Received an error posting %s: %s!`, i, err.Error())
+		c.release(x, f, n)
+		return addFailed
+	}
+	s.Message = k.MessageID
+	if err = f.hooks.call(x, s, Hook.AfterPost); err != nil {
+		f.event(x, logx.Info, `Submission %s was rejected after posting, removing Message "%d": %s!`, i, k.MessageID, err.Error())
+		c.enqueue(x, f, o, c.channel(), k.MessageID)
+		c.release(x, f, n)
+		return addRejected
+	}
+	b, err := f.sql.Bind(x, n, uint64(k.MessageID))
+	if err != nil {
+		f.event(x, logx.Error, `Received an error binding Message "%d" to "%d": %s!`, k.MessageID, n, err.Error())
+		c.enqueue(x, f, o, c.channel(), k.MessageID)
+		c.release(x, f, n)
+		return addFailed
+	}
+	if !b {
+		f.event(x, logx.Error, `Reservation "%d" was removed before Message "%d" was bound, removing the Message!`, n, k.MessageID)
+		c.enqueue(x, f, o, c.channel(), k.MessageID)
 		return addFailed
 	}
-	f.log.Debug(`[bot %d]: Update to Placeholder "%d" with %s completed!`, c.bot.Self.ID, p, i)
+	f.event(x, logx.Debug, `Post of %s as Message "%d" completed!`, i, k.MessageID)
+	c.save(x, f, i, k.MessageID, d)
 	return addSuccess
 }
 func (c *container) send(x context.Context, f *Forwarder, g *sync.WaitGroup, o <-chan telegram.Chattable) {
-	f.log.Debug("[bot %d]: Starting Telegram sender thread..", c.bot.Self.ID)
-	for g.Add(1); ; {
+	f.event(x, logx.Debug, "Starting Telegram sender thread..")
+	s := make(map[uint64]bool)
+	c.replay(x, f, s)
+	for {
 		select {
+		case <-c.wake:
+			c.replay(x, f, s)
 		case n := <-o:
-			if _, err := c.bot.Request(n); err != nil {
-				f.log.Error(`[bot %d]: Error sending Telegram message to chat: %s!`, c.bot.Self.ID, err.Error())
+			if n == nil {
+				break
 			}
+			y := x
+			if t, ok := n.(tagged); ok {
+				y, n = withEntry(x, &entry{ID: t.id, Bot: c.bot.Self().ID}), t.Chattable
+			}
+			if q := len(o); q >= cap(o)/2 {
+				f.event(y, logx.Warning, "Telegram sender queue is backing up (%d/%d pending)!", q, cap(o))
+			} else {
+				f.event(y, logx.Trace, "Sending Telegram message (%d/%d pending)..", q, cap(o))
+			}
+			c.deliver(y, f, n)
 		case <-x.Done():
-			f.log.Debug("Stopping Telegram sender thread.")
+			f.event(x, logx.Debug, "Stopping Telegram sender thread.")
 			g.Done()
 			return
 		}
 	}
 }
-func (c *container) delete(x context.Context, f *Forwarder, v, m string, o chan<- telegram.Chattable) bool {
-	f.log.Trace(`[bot %d]: Processing ID "%s" for deletion..`, c.bot.Self.ID, v)
-	i, err := loadImage(x, c.bot, v, m)
+
+// deliver sends the Chattable and removes its Queue entry, if it has one. This
+// returns false if the request failed.
+func (c *container) deliver(x context.Context, f *Forwarder, n telegram.Chattable) bool {
+	q, ok := n.(queued)
+	if ok {
+		n = q.Chattable
+	}
+	_, err := c.call(x, f, n)
 	if err != nil {
-		f.log.Error(`[bot %d]: Received an error processing Image "%s" (mime: %s): %s!`, c.bot.Self.ID, v, m, err.Error())
-		return false
+		f.event(x, logx.Error, `Error sending Telegram message to chat: %s!`, err.Error())
 	}
-	f.log.Debug("[bot %d]: Processing complete: %s", c.bot.Self.ID, i)
-	var (
-		e uint64
-		r *sql.Rows
-	)
-	if r, err = f.sql.QueryContext(x, "delete", i.Sum, c.bot.Self.ID); err != nil {
-		f.log.Error(`[bot %d]: Received an error querying the database for %s: %s!`, c.bot.Self.ID, i, err.Error())
+	if ok && x.Err() == nil {
+		f.dequeue(x, q, err)
+	}
+	return err == nil
+}
+func (c *container) delete(x context.Context, f *Forwarder, v, m string, o chan<- telegram.Chattable) bool {
+	f.event(x, logx.Trace, `Processing ID "%s" for deletion..`, v)
+	i, err := loadImage(x, f, c, v, m)
+	if err != nil {
+		f.event(x, logx.Error, `Received an error processing Image "%s" (mime: %s): %s!`, v, m, err.Error())
 		return false
 	}
-	for r.Next() {
-		if err = r.Scan(&e); err != nil {
-			break
+	f.event(x, logx.Debug, "Processing complete: %s", i)
+	if len(f.hooks) > 0 {
+		s := c.submission(x, v, m, "")
+		s.Hash, s.File = i.Average, i.Sum
+		if k, err := f.sql.FindFile(x, c.bot.Self().ID, i.Sum); err == nil && len(k) > 0 {
+			s.Message = int(k[0].Message)
+		}
+		if err = f.hooks.call(x, s, Hook.OnDelete); err != nil {
+			f.event(x, logx.Info, `Delete of %s was rejected: %s!`, i, err.Error())
+			return false
 		}
 	}
-	switch r.Close(); {
+	e, err := f.sql.Delete(x, c.bot.Self().ID, i.Sum)
+	switch {
 	case err != nil:
-		f.log.Error("[bot %d]: Received an error scanning the query results: %s!", c.bot.Self.ID, err.Error())
+		f.event(x, logx.Error, `Received an error querying the database for %s: %s!`, i, err.Error())
 		return false
 	case e != 0:
-		f.log.Debug(`[bot %d]: Removing Message with ID "%d"..`, c.bot.Self.ID, e)
-		o <- telegram.NewDeleteMessage(c.recv, int(e))
+		f.event(x, logx.Debug, `Removing Message with ID "%d"..`, e)
+		c.enqueue(x, f, o, c.channel(), int(e))
 	}
 	return true
 }
+
+// poll receives updates with long polling and passes them to the receiver thread
+// until the context is canceled. Failed requests are retried after 'pollRetry'.
+func (c *container) poll(x context.Context, f *Forwarder, o chan<- telegram.Update) {
+	u := telegram.UpdateConfig{Timeout: pollTimeout}
+	for x.Err() == nil {
+		r, err := c.bot.GetUpdates(x, u)
+		if err != nil {
+			if x.Err() != nil {
+				return
+			}
+			f.event(x, logx.Warning, "Receiving updates failed, retrying in %s: %s!", pollRetry, err.Error())
+			if sleep(x, pollRetry) != nil {
+				return
+			}
+			continue
+		}
+		c.health.polled.Store(time.Now().UnixNano())
+		for i := range r {
+			if r[i].UpdateID < u.Offset {
+				continue
+			}
+			u.Offset = r[i].UpdateID + 1
+			select {
+			case o <- r[i]:
+			case <-x.Done():
+				return
+			}
+		}
+	}
+}
 func (c *container) receive(x context.Context, f *Forwarder, g *sync.WaitGroup, o chan<- telegram.Chattable, r <-chan telegram.Update) {
-	f.log.Debug("[bot %d]: Starting Telegram receiver thread..", c.bot.Self.ID)
-	for g.Add(1); ; {
+	f.event(x, logx.Debug, "Starting Telegram receiver thread..")
+	c.health.running.Store(true)
+	for {
 		select {
 		case n := <-r:
+			c.health.seen()
 			if n.Message == nil || n.Message.Chat == nil {
 				break
 			}
 			if !n.Message.Chat.IsPrivate() || n.Message.From.IsBot {
 				break
 			}
+			y, e := c.trace(x, n.Message.From.ID)
 			if !c.isAuthorized(n.Message.From.ID) {
-				f.log.Trace(`[bot %d]: Unauthorized user "@%s" (%d) attempted to use the bot!`, c.bot.Self.ID, n.Message.From.UserName, n.Message.From.ID)
-				o <- telegram.NewMessage(n.Message.Chat.ID, "Sorry, I don't know you.")
+				f.event(y, logx.Trace, `Unauthorized user "@%s" (%d) attempted to use the bot!`, n.Message.From.UserName, n.Message.From.ID)
+				f.stats.inc("forwarder_unauthorized_total", c.label())
+				o <- e.tag(telegram.NewMessage(n.Message.Chat.ID, "Sorry, I don't know you."))
 				break
 			}
 			i, m := getTarget(n.Message)
@@ -260,9 +374,9 @@ func (c *container) receive(x context.Context, f *Forwarder, g *sync.WaitGroup,
 				switch {
 				case len(n.Message.Text) == 0:
 				case strings.HasPrefix(n.Message.Text, "/del"):
-					o <- telegram.NewMessage(n.Message.Chat.ID, `Use "/delete" with an image to delete it.`)
+					o <- e.tag(telegram.NewMessage(n.Message.Chat.ID, `Use "/delete" with an image to delete it.`))
 				case strings.HasPrefix(n.Message.Text, "/clear"):
-					o <- telegram.NewMessage(n.Message.Chat.ID, `Removed any current cached caption!`)
+					o <- e.tag(telegram.NewMessage(n.Message.Chat.ID, `Removed any current cached caption!`))
 					f.caps.clear(n.Message.From.ID)
 				default:
 					f.caps.set(n.Message.From.ID, n.Message.Text)
@@ -273,12 +387,17 @@ func (c *container) receive(x context.Context, f *Forwarder, g *sync.WaitGroup,
 			case len(n.Message.Text) > 3 && n.Message.Text[0] == '/' && strings.HasPrefix(n.Message.Text, "/del"):
 				fallthrough
 			case len(n.Message.Caption) > 3 && n.Message.Caption[0] == '/' && strings.HasPrefix(n.Message.Caption, "/del"):
-				f.log.Trace("[bot %d]: Received a possible delete command from %s!", c.bot.Self.ID, n.Message.From.String())
-				if f.caps.clear(n.Message.From.ID); c.delete(x, f, i, m, o) {
-					o <- telegram.NewMessage(n.Message.Chat.ID, "I've removed that image! (if it existed!)")
+				f.event(y, logx.Trace, "Received a possible delete command from %s!", n.Message.From.String())
+				if f.caps.clear(n.Message.From.ID); c.delete(y, f, i, m, o) {
+					e.finish("success")
+					f.stats.inc("forwarder_delete_total", c.label("outcome", "success"))
+					o <- e.tag(telegram.NewMessage(n.Message.Chat.ID, "I've removed that image! (if it existed!)"))
 				} else {
-					o <- telegram.NewMessage(n.Message.Chat.ID, "I'm sorry, but I cannot process that image.")
+					e.finish("failed")
+					f.stats.inc("forwarder_delete_total", c.label("outcome", "failed"))
+					o <- e.tag(telegram.NewMessage(n.Message.Chat.ID, "I'm sorry, but I cannot process that image."))
 				}
+				f.event(y, logx.Info, `Finished deleting "%s" with outcome "%s" in %s.`, i, e.Outcome, e.Duration)
 			default:
 				var (
 					s  string
@@ -295,30 +414,37 @@ func (c *container) receive(x context.Context, f *Forwarder, g *sync.WaitGroup,
 				} else if s, ok = f.caps.get(n.Message.From.ID, true); !ok {
 					s = n.Message.Caption
 				}
-				switch c.add(x, f, i, m, s, o) {
+				a := c.add(y, f, i, m, s, o)
+				e.finish(outcome(a))
+				f.stats.inc("forwarder_add_total", c.label("outcome", outcome(a)))
+				f.event(y, logx.Info, `Finished processing "%s" with outcome "%s" in %s.`, i, e.Outcome, e.Duration)
+				switch a {
 				case addFailed:
-					o <- telegram.NewMessage(n.Message.Chat.ID, "I'm sorry, but I cannot process that image.")
+					o <- e.tag(telegram.NewMessage(n.Message.Chat.ID, "I'm sorry, but I cannot process that image."))
+				case addRejected:
+					o <- e.tag(telegram.NewMessage(n.Message.Chat.ID, "I'm sorry, but that image was rejected."))
 				case addSuccess:
-					o <- telegram.MessageConfig{
+					o <- e.tag(telegram.MessageConfig{
 						Text:                  "I've added that image!",
 						BaseChat:              telegram.BaseChat{ChatID: n.Message.Chat.ID, ReplyToMessageID: 0, DisableNotification: true},
 						DisableWebPagePreview: false,
-					}
+					})
 				case addIsNotImage:
-					o <- telegram.NewMessage(
+					o <- e.tag(telegram.NewMessage(
 						n.Message.Chat.ID,
 						"I'm sorry, I couldn't get an image hash for that, but I tried to upload it as a video instead!",
-					)
+					))
 				default:
-					o <- telegram.MessageConfig{
+					o <- e.tag(telegram.MessageConfig{
 						Text:                  "I've seen that image before.",
 						BaseChat:              telegram.BaseChat{ChatID: n.Message.Chat.ID, ReplyToMessageID: 0, DisableNotification: true},
 						DisableWebPagePreview: false,
-					}
+					})
 				}
 			}
 		case <-x.Done():
-			f.log.Debug("Stopping Telegram receiver thread.")
+			f.event(x, logx.Debug, "Stopping Telegram receiver thread.")
+			c.health.running.Store(false)
 			g.Done()
 			return
 		}
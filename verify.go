@@ -0,0 +1,125 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"strconv"
+
+	"github.com/PurpleSec/logx"
+)
+
+// Report is the result of verifying the stored hashes of a bot against hashes
+// computed from the media posted in the Channel.
+type Report struct {
+	// Bot is the ID of the bot the records belong to.
+	Bot int64
+	// Checked is the number of records that were fetched and hashed.
+	Checked int
+	// Failed is the number of records that could not be fetched or hashed.
+	Failed int
+	// Image is the number of records with a different Image (perceptual) hash.
+	Image int
+	// File is the number of records with a different file (SHA512) hash.
+	File int
+	// Distance is the total hamming distance of the mismatched Image hashes.
+	Distance int
+	// Repaired is the number of records that were updated with the new hashes.
+	Repaired int
+}
+
+// Rate returns the ratio of checked records with a mismatched Image hash.
+func (r Report) Rate() float64 {
+	if r.Checked == 0 {
+		return 0
+	}
+	return float64(r.Image) / float64(r.Checked)
+}
+
+// Verify samples up to 'n' records of each bot, fetches the posted media through
+// the bot and recomputes the hashes, to check that imported hashes match the
+// hashes used for new submissions. If 'repair' is true, mismatched records are
+// updated with the recomputed hashes.
+//
+// Each bot must have a "scratch_chat_id", as fetching media forwards each Message
+// to it. The Forwarder must not be running while verifying.
+func (f *Forwarder) Verify(n int, repair bool) ([]Report, error) {
+	var (
+		x, y = interruptible()
+		l    = f.list()
+		r    = make([]Report, 0, len(l))
+		err  error
+	)
+	for _, c := range l {
+		v, err2 := c.verify(x, f, n, repair)
+		if r = append(r, v); err2 != nil {
+			err = err2
+			f.sql.Close()
+			break
+		}
+	}
+	y()
+	return r, err
+}
+func (c *container) verify(x context.Context, f *Forwarder, n int, w bool) (Report, error) {
+	r := Report{Bot: c.bot.Self().ID}
+	c.lock.RLock()
+	k := c.scratch
+	c.lock.RUnlock()
+	if k == 0 {
+		return r, errors.New("bot " + strconv.FormatInt(r.Bot, 10) + ": " + errNoScratch.Error())
+	}
+	e, err := records(f.sql.Sample(x, c.bot.Self().ID, n))
+	if err != nil {
+		return r, err
+	}
+	f.event(c.scope(x), logx.Info, "Verifying %d sampled records..", len(e))
+	for _, k := range e {
+		if err = x.Err(); err != nil {
+			return r, err
+		}
+		y := c.scope(x)
+		v, err := c.rehash(y, f, k, w)
+		if err != nil {
+			f.event(y, logx.Warning, `Could not verify record "%d" (Message "%d"): %s!`, k.ID, k.Message, err.Error())
+			r.Failed++
+			continue
+		}
+		r.Checked++
+		if v.New.File != v.Old.File {
+			r.File++
+		}
+		if v.New.Image != v.Old.Image {
+			var (
+				a, _ = strconv.ParseUint(v.Old.Image, 16, 64)
+				b, _ = strconv.ParseUint(v.New.Image, 16, 64)
+				d    = bits.OnesCount64(a ^ b)
+			)
+			r.Image, r.Distance = r.Image+1, r.Distance+d
+			f.event(y, logx.Debug, `Record "%d" (Message "%d") Image hash mismatch "%s" != "%s" (distance %d).`, k.ID, k.Message, v.Old.Image, v.New.Image, d)
+		}
+		if v.Changed && w {
+			r.Repaired++
+		}
+	}
+	f.event(c.scope(x), logx.Info, "Verified %d records (%d failed), %d Image and %d file hash mismatches (%.2f%%), %d repaired.",
+		r.Checked, r.Failed, r.Image, r.File, r.Rate()*100, r.Repaired,
+	)
+	return r, nil
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	hookHeader   = "X-Telegram-Bot-Api-Secret-Token"
+	updateBuffer = 100
+)
+
+type webhook struct {
+	srv   *http.Server
+	url   string
+	cert  string
+	key   string
+	bots  map[string]*container
+	lock  sync.RWMutex
+	self  bool
+	ready bool
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+func newWebhook(h WebhookConfig) *webhook {
+	w := &webhook{
+		url:  strings.TrimSuffix(h.URL, "/"),
+		cert: h.Cert,
+		key:  h.Key,
+		self: h.SelfSigned,
+		bots: make(map[string]*container),
+	}
+	w.srv = &http.Server{
+		Addr:              h.Listen,
+		Handler:           w,
+		ReadTimeout:       time.Second * 30,
+		WriteTimeout:      time.Second * 30,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	return w
+}
+func (w *webhook) listen(f *Forwarder) error {
+	// Load the certificate before listening, so a bad certificate or key fails
+	// here and the bots fall back to polling instead of registering a Webhook
+	// that can never be reached.
+	if len(w.cert) > 0 {
+		k, err := tls.LoadX509KeyPair(w.cert, w.key)
+		if err != nil {
+			return errors.New("loading the certificate failed: " + err.Error())
+		}
+		w.srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{k}, MinVersion: tls.VersionTLS12}
+	}
+	l, err := net.Listen("tcp", w.srv.Addr)
+	if err != nil {
+		return err
+	}
+	w.ready = true
+	go func() {
+		var err error
+		if w.srv.TLSConfig != nil {
+			err = w.srv.ServeTLS(l, "", "")
+		} else {
+			err = w.srv.Serve(l)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			f.log.Error("Webhook listener stopped: %s!", err.Error())
+		}
+	}()
+	return nil
+}
+func (w *webhook) shutdown() error {
+	if !w.ready {
+		return nil
+	}
+	x, c := context.WithTimeout(context.Background(), time.Second*10)
+	err := w.srv.Shutdown(x)
+	c()
+	return err
+}
+func (w *webhook) remove(c *container) error {
+	w.lock.Lock()
+	delete(w.bots, c.hookPath())
+	w.lock.Unlock()
+	x, y := context.WithTimeout(context.Background(), time.Second*10)
+	_, err := c.bot.Request(x, telegram.DeleteWebhookConfig{})
+	y()
+	return err
+}
+
+// register sets the Webhook of the bot to a new random path, replacing any path
+// from a previous registration. Updates are only accepted while the context 'x'
+// of the bot is running.
+func (w *webhook) register(x context.Context, c *container) error {
+	if !w.ready {
+		return errors.New("webhook listener is not running")
+	}
+	var (
+		p    = "/" + randomHex(16)
+		s    = randomHex(32)
+		v    = telegram.Params{"url": w.url + p, "secret_token": s}
+		err  error
+		z, y = context.WithTimeout(context.Background(), time.Second*10)
+	)
+	if w.self {
+		_, err = c.bot.Call(z, "setWebhook", v, telegram.RequestFile{Name: "certificate", Data: telegram.FilePath(w.cert)})
+	} else {
+		_, err = c.bot.Call(z, "setWebhook", v)
+	}
+	if y(); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	o := c.path
+	c.path, c.secret, c.done = p, s, x.Done()
+	c.updates = make(chan telegram.Update, updateBuffer)
+	c.lock.Unlock()
+	w.lock.Lock()
+	delete(w.bots, o)
+	w.bots[p] = c
+	w.lock.Unlock()
+	return nil
+}
+func (w *webhook) ServeHTTP(r http.ResponseWriter, q *http.Request) {
+	w.lock.RLock()
+	c, ok := w.bots[q.URL.Path]
+	w.lock.RUnlock()
+	if !ok {
+		http.NotFound(r, q)
+		return
+	}
+	c.lock.RLock()
+	s, o, d := c.secret, c.updates, c.done
+	c.lock.RUnlock()
+	if subtle.ConstantTimeCompare([]byte(q.Header.Get(hookHeader)), []byte(s)) != 1 {
+		http.Error(r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if q.Method != http.MethodPost {
+		http.Error(r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var u telegram.Update
+	if err := json.NewDecoder(q.Body).Decode(&u); err != nil {
+		http.Error(r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A stopped bot no longer reads its updates, so Telegram is told to retry
+	// instead of the update being lost or blocking until the request times out.
+	select {
+	case <-d:
+		r.WriteHeader(http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	select {
+	case o <- u:
+		r.WriteHeader(http.StatusOK)
+	case <-d:
+		r.WriteHeader(http.StatusServiceUnavailable)
+	case <-q.Context().Done():
+		r.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2021 - 2025 PurpleSec Team
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	a := newFakeAPI(t)
+	c, err := newContainer(0, BotConfig{Key: fakeToken, API: a.api(), Files: a.fileURL(), Channel: testChannel}, func(b BotConfig) (Client, error) {
+		return NewClient(b, a.srv.Client())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		w    = newWebhook(WebhookConfig{URL: "https://example.com/"})
+		x, y = context.WithCancel(context.Background())
+	)
+	defer y()
+	w.ready = true
+	if err = w.register(x, c); err != nil {
+		t.Fatal(err)
+	}
+	o := c.hookPath()
+	if err = w.register(x, c); err != nil {
+		t.Fatal(err)
+	}
+	p := c.hookPath()
+	if len(w.bots) != 1 || w.bots[p] != c {
+		t.Fatalf("expected only the new path to be registered, got %v", w.bots)
+	}
+	for _, v := range []struct {
+		name, method, path, secret string
+		stop                       bool
+		code                       int
+	}{
+		{"old path", http.MethodPost, o, c.secret, false, http.StatusNotFound},
+		{"wrong secret", http.MethodPost, p, "nope", false, http.StatusUnauthorized},
+		{"wrong method", http.MethodGet, p, c.secret, false, http.StatusMethodNotAllowed},
+		{"update", http.MethodPost, p, c.secret, false, http.StatusOK},
+		{"stopped", http.MethodPost, p, c.secret, true, http.StatusServiceUnavailable},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			if v.stop {
+				y()
+			}
+			var (
+				r = httptest.NewRequest(v.method, v.path, strings.NewReader(`{"update_id": 1}`))
+				e = httptest.NewRecorder()
+			)
+			r.Header.Set(hookHeader, v.secret)
+			if w.ServeHTTP(e, r); e.Code != v.code {
+				t.Fatalf("expected status %d, got %d", v.code, e.Code)
+			}
+		})
+	}
+	if n := len(c.updates); n != 1 {
+		t.Fatalf("expected 1 update to be received, got %d", n)
+	}
+}